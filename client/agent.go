@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tracing"
+)
+
+// ErrNoTask is returned by Fetch when there are no tasks to compute.
+var ErrNoTask = errors.New("no task to compute")
+
+// AgentClient calls the internal API of the orchestrator on behalf of an
+// agent. ID names the agent when the orchestrator does not authenticate
+// agents, Token is its credential when it does.
+//
+// Operations, Precisions and Capacity are advertised with every request, so
+// the orchestrator hands out only the tasks the agent can compute and no more
+// than Capacity of them at once. Empty values advertise nothing: the agent
+// gets every float task without a limit. Tasks of user functions are handed
+// out only when Functions is set. OperationTimes is the time the agent
+// spends on the operations the orchestrator sends no time for, so batches
+// fit in their leases.
+type AgentClient struct {
+	BaseURL    string
+	ID         string
+	Token      string
+	Operations []string
+	Precisions []string
+	Capacity   int
+	Functions  bool
+	HTTP       *http.Client
+	Retry      Retry
+
+	// OperationTimes is advertised in milliseconds.
+	OperationTimes map[string]time.Duration
+}
+
+func NewAgentClient(baseURL, id, token string) *AgentClient {
+	return &AgentClient{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		ID:      id,
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+		Retry:   DefaultRetry,
+	}
+}
+
+// Fetch leases the next task to the agent. The result must be submitted by
+// the same agent before the lease expires.
+func (c *AgentClient) Fetch(ctx context.Context) (*Task, error) {
+	resp, err := send(ctx, c.HTTP, c.Retry, http.MethodGet, c.BaseURL+"/internal/task", nil, c.header(), http.StatusOK)
+	if err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return nil, ErrNoTask
+		}
+		return nil, err
+	}
+	defer drain(resp)
+
+	var body struct {
+		Task *Task `json:"task"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body.Task == nil {
+		return nil, errors.New("the response has no task")
+	}
+	body.Task.traceparent = resp.Header.Get("traceparent")
+	return body.Task, nil
+}
+
+// FetchBatch leases up to limit tasks to the agent in one request, each with
+// its own lease. The orchestrator may hand out fewer tasks than asked for.
+func (c *AgentClient) FetchBatch(ctx context.Context, limit int) ([]*Task, error) {
+	path := c.BaseURL + "/internal/tasks?limit=" + strconv.Itoa(limit)
+	resp, err := send(ctx, c.HTTP, c.Retry, http.MethodGet, path, nil, c.header(), http.StatusOK)
+	if err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return nil, ErrNoTask
+		}
+		return nil, err
+	}
+	defer drain(resp)
+
+	var body struct {
+		Tasks []struct {
+			Task
+			Traceparent string `json:"traceparent"`
+		} `json:"tasks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if len(body.Tasks) == 0 {
+		return nil, ErrNoTask
+	}
+	tasks := make([]*Task, len(body.Tasks))
+	for i, t := range body.Tasks {
+		task := t.Task
+		task.traceparent = t.Traceparent
+		tasks[i] = &task
+	}
+	return tasks, nil
+}
+
+// SubmitBatch sends the results of several tasks in one request. The
+// returned slice holds the outcome of each result in the same order: nil
+// when it was accepted, a *StatusError when it was rejected. The error is
+// set when the request as a whole failed.
+func (c *AgentClient) SubmitBatch(ctx context.Context, results []TaskResult) ([]error, error) {
+	resp, err := send(ctx, c.HTTP, c.Retry, http.MethodPost, c.BaseURL+"/internal/tasks", map[string][]TaskResult{"results": results}, c.header(), http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer drain(resp)
+
+	var body struct {
+		Results []struct {
+			ID     int    `json:"id"`
+			Status int    `json:"status"`
+			Error  string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if len(body.Results) != len(results) {
+		return nil, errors.New("the response does not match the results")
+	}
+	errs := make([]error, len(results))
+	for i, r := range body.Results {
+		if r.Status != http.StatusOK {
+			errs[i] = &StatusError{StatusCode: r.Status, Message: r.Error}
+		}
+	}
+	return errs, nil
+}
+
+// Submit sends the result of a task leased by Fetch.
+func (c *AgentClient) Submit(ctx context.Context, result TaskResult) error {
+	resp, err := send(ctx, c.HTTP, c.Retry, http.MethodPost, c.BaseURL+"/internal/task", result, c.header(), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
+
+// Release gives a leased task back, so another agent can take it without
+// waiting for the lease to expire.
+func (c *AgentClient) Release(ctx context.Context, id int) error {
+	resp, err := send(ctx, c.HTTP, c.Retry, http.MethodDelete, c.BaseURL+"/internal/task/"+strconv.Itoa(id), nil, c.header(), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
+
+// Module downloads the WebAssembly module of a user function by the digest
+// sent with its task.
+func (c *AgentClient) Module(ctx context.Context, digest string) ([]byte, error) {
+	resp, err := send(ctx, c.HTTP, c.Retry, http.MethodGet, c.BaseURL+"/internal/functions/"+digest, nil, c.header(), http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (c *AgentClient) header() http.Header {
+	header := http.Header{}
+	if c.ID != "" {
+		header.Set("X-Agent-ID", c.ID)
+	}
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if len(c.Operations) > 0 {
+		header.Set("X-Agent-Operations", strings.Join(c.Operations, ","))
+	}
+	if len(c.Precisions) > 0 {
+		header.Set("X-Agent-Precision", strings.Join(c.Precisions, ","))
+	}
+	if c.Capacity > 0 {
+		header.Set("X-Agent-Capacity", strconv.Itoa(c.Capacity))
+	}
+	if c.Functions {
+		header.Set("X-Agent-Functions", "true")
+	}
+	if len(c.OperationTimes) > 0 {
+		times := make([]string, 0, len(c.OperationTimes))
+		for op, d := range c.OperationTimes {
+			times = append(times, op+"="+strconv.FormatInt(d.Milliseconds(), 10))
+		}
+		sort.Strings(times)
+		header.Set("X-Agent-Operation-Times", strings.Join(times, ","))
+	}
+	return header
+}
+
+// TraceContext returns ctx carrying the trace context the orchestrator sent
+// with the task, so spans of the agent join the trace of the expression.
+func (t *Task) TraceContext(ctx context.Context) context.Context {
+	if t.traceparent == "" {
+		return ctx
+	}
+	return tracing.Extract(ctx, http.Header{"Traceparent": {t.traceparent}})
+}
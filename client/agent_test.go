@@ -0,0 +1,71 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/client"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAgentClient(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.AgentTokens = map[string]string{"token-1": "agent-1", "token-2": "agent-2"}
+	srv := httptest.NewServer(o.Router())
+	defer srv.Close()
+
+	ctx := context.Background()
+	first := client.NewAgentClient(srv.URL, "", "token-1")
+	second := client.NewAgentClient(srv.URL, "", "token-2")
+	if _, err := client.NewAgentClient(srv.URL, "", "").Fetch(ctx); !isStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("fetch without a token: got %v", err)
+	}
+	if _, err := first.Fetch(ctx); !errors.Is(err, client.ErrNoTask) {
+		t.Fatalf("fetch from an empty queue: got %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression":"6*7"}`))
+	o.AddExpression(w, r.WithContext(auth.WithUser(r.Context(), "alice")))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("invalid status code: got %v want %v", w.Code, http.StatusCreated)
+	}
+
+	task, err := first.Fetch(ctx)
+	if err != nil || task.ID != 1 || task.ExpressionID != 1 || task.Operation != "*" || task.Arg1 != 6 || task.Arg2 != 7 {
+		t.Fatalf("invalid task: %+v, %v", task, err)
+	}
+	if sc := trace.SpanContextFromContext(task.TraceContext(ctx)); sc.IsValid() {
+		t.Errorf("a trace context was found without tracing: %v", sc)
+	}
+	if err := second.Release(ctx, task.ID); !isStatus(err, http.StatusForbidden) {
+		t.Fatalf("release by another agent: got %v", err)
+	}
+	if err := first.Release(ctx, task.ID); err != nil {
+		t.Fatalf("failed to release: %v", err)
+	}
+
+	task, err = second.Fetch(ctx)
+	if err != nil || task.ID != 1 {
+		t.Fatalf("the released task was not handed out again: %+v, %v", task, err)
+	}
+	if err := first.Submit(ctx, client.TaskResult{ID: task.ID, Result: 42}); !isStatus(err, http.StatusForbidden) {
+		t.Fatalf("result from the previous lease holder: got %v", err)
+	}
+	if err := second.Submit(ctx, client.TaskResult{ID: task.ID, Result: 42}); err != nil {
+		t.Fatalf("failed to submit: %v", err)
+	}
+	if err := second.Submit(ctx, client.TaskResult{ID: task.ID, Result: 42}); !isStatus(err, http.StatusConflict) {
+		t.Fatalf("repeated result: got %v", err)
+	}
+	if expr := o.Exprs[1]; expr.Status != "resolved" || expr.Result != 42 {
+		t.Errorf("invalid expression: %+v", expr)
+	}
+}
@@ -0,0 +1,326 @@
+// Package client calls the API of the distributed calculator: Client is used
+// by applications submitting expressions and AgentClient by agents computing
+// tasks.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tracing"
+)
+
+// Retry controls how failed calls are repeated. Calls are repeated after
+// network errors and after 429, 502, 503 and 504 responses, waiting twice as
+// long before every next attempt, or as long as the Retry-After header says.
+type Retry struct {
+	// Attempts is the number of attempts, values below 2 disable retries.
+	Attempts   int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+var DefaultRetry = Retry{Attempts: 3, MinBackoff: 100 * time.Millisecond, MaxBackoff: 2 * time.Second}
+
+// StatusError is returned when the orchestrator answers with an unexpected
+// status code. Message is the error text sent by the orchestrator.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client calls the public API of the orchestrator on behalf of a user.
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+	Retry   Retry
+}
+
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+		Retry:   DefaultRetry,
+	}
+}
+
+func (c *Client) Register(ctx context.Context, login, password string) error {
+	body := map[string]string{"login": login, "password": password}
+	return c.call(ctx, http.MethodPost, "/api/v1/register", body, nil, http.StatusOK, nil)
+}
+
+// Login returns a token of the user, which the client then sends with every
+// request.
+func (c *Client) Login(ctx context.Context, login, password string) (string, error) {
+	body := map[string]string{"login": login, "password": password}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/v1/login", body, nil, http.StatusOK, &resp); err != nil {
+		return "", err
+	}
+	c.Token = resp.Token
+	return resp.Token, nil
+}
+
+// Submit sends the expression for computation and returns its id. The call
+// carries an idempotency key, so a retried submission creates the expression
+// only once.
+func (c *Client) Submit(ctx context.Context, expression string) (int, error) {
+	return c.SubmitPrecision(ctx, expression, "")
+}
+
+// SubmitPrecision is Submit computing the expression in the given precision
+// mode, PrecisionFloat or PrecisionBig.
+func (c *Client) SubmitPrecision(ctx context.Context, expression, precision string) (int, error) {
+	key := make([]byte, 16)
+	rand.Read(key)
+	header := http.Header{"Idempotency-Key": {hex.EncodeToString(key)}}
+	var resp struct {
+		ID int `json:"id"`
+	}
+	body := map[string]string{"expression": expression}
+	if precision != "" {
+		body["precision"] = precision
+	}
+	err := c.call(ctx, http.MethodPost, "/api/v1/calculate", body, header, http.StatusCreated, &resp)
+	return resp.ID, err
+}
+
+func (c *Client) Get(ctx context.Context, id int) (Expression, error) {
+	var resp struct {
+		Expression Expression `json:"expression"`
+	}
+	err := c.call(ctx, http.MethodGet, "/api/v1/expressions/"+strconv.Itoa(id), nil, nil, http.StatusOK, &resp)
+	return resp.Expression, err
+}
+
+func (c *Client) List(ctx context.Context) ([]Expression, error) {
+	var resp struct {
+		Expressions []Expression `json:"expressions"`
+	}
+	err := c.call(ctx, http.MethodGet, "/api/v1/expressions", nil, nil, http.StatusOK, &resp)
+	return resp.Expressions, err
+}
+
+// Tasks lists the tasks of the expression in the order they were created.
+func (c *Client) Tasks(ctx context.Context, id int) ([]ExpressionTask, error) {
+	var resp struct {
+		Tasks []ExpressionTask `json:"tasks"`
+	}
+	err := c.call(ctx, http.MethodGet, "/api/v1/expressions/"+strconv.Itoa(id)+"/tasks", nil, nil, http.StatusOK, &resp)
+	return resp.Tasks, err
+}
+
+// Cancel stops the computation of the expression and returns its state.
+func (c *Client) Cancel(ctx context.Context, id int) (Expression, error) {
+	var resp struct {
+		Expression Expression `json:"expression"`
+	}
+	err := c.call(ctx, http.MethodPost, "/api/v1/expressions/"+strconv.Itoa(id)+"/cancel", nil, nil, http.StatusOK, &resp)
+	return resp.Expression, err
+}
+
+// Costs returns the time agents spend on each operation. Zero means the
+// agents use their own setting. The client must carry the administrator
+// token of the server as Token.
+func (c *Client) Costs(ctx context.Context) (map[string]time.Duration, error) {
+	var resp struct {
+		Costs map[string]int64 `json:"costs_ms"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/api/v1/admin/costs", nil, nil, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+	return fromMillis(resp.Costs), nil
+}
+
+// SetCosts changes the time of the given operations and returns the whole
+// table. The client must carry the administrator token as Token.
+func (c *Client) SetCosts(ctx context.Context, costs map[string]time.Duration) (map[string]time.Duration, error) {
+	body := map[string]map[string]int64{"costs_ms": {}}
+	for op, cost := range costs {
+		body["costs_ms"][op] = cost.Milliseconds()
+	}
+	var resp struct {
+		Costs map[string]int64 `json:"costs_ms"`
+	}
+	if err := c.call(ctx, http.MethodPut, "/api/v1/admin/costs", body, nil, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+	return fromMillis(resp.Costs), nil
+}
+
+// AddFunction uploads a WebAssembly module as the function name of the
+// user, which expressions then call as name(x). The module must export a
+// function called name taking one f64 and returning one f64.
+func (c *Client) AddFunction(ctx context.Context, name string, module []byte) (Function, error) {
+	body := map[string]interface{}{"name": name, "module": module}
+	var resp struct {
+		Function Function `json:"function"`
+	}
+	err := c.call(ctx, http.MethodPost, "/api/v1/functions", body, nil, http.StatusCreated, &resp)
+	return resp.Function, err
+}
+
+// Functions lists the functions of the user.
+func (c *Client) Functions(ctx context.Context) ([]Function, error) {
+	var resp struct {
+		Functions []Function `json:"functions"`
+	}
+	err := c.call(ctx, http.MethodGet, "/api/v1/functions", nil, nil, http.StatusOK, &resp)
+	return resp.Functions, err
+}
+
+// DeleteFunction removes the function of the user. Expressions already
+// submitted still compute it.
+func (c *Client) DeleteFunction(ctx context.Context, name string) error {
+	return c.call(ctx, http.MethodDelete, "/api/v1/functions/"+url.PathEscape(name), nil, nil, http.StatusNoContent, nil)
+}
+
+func fromMillis(ms map[string]int64) map[string]time.Duration {
+	costs := make(map[string]time.Duration, len(ms))
+	for op, cost := range ms {
+		costs[op] = time.Duration(cost) * time.Millisecond
+	}
+	return costs
+}
+
+// Wait polls the expression every interval until it is resolved or
+// cancelled, or until the context is done.
+func (c *Client) Wait(ctx context.Context, id int, interval time.Duration) (Expression, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		expr, err := c.Get(ctx, id)
+		if err != nil || expr.Finished() {
+			return expr, err
+		}
+		select {
+		case <-ctx.Done():
+			return expr, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) call(ctx context.Context, method, path string, body interface{}, header http.Header, want int, out interface{}) error {
+	if header == nil {
+		header = http.Header{}
+	}
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := send(ctx, c.HTTP, c.Retry, method, c.BaseURL+path, body, header, want)
+	if err != nil {
+		return err
+	}
+	defer drain(resp)
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send performs the call, repeating it according to the retry policy, and
+// returns the response if it has the wanted status code. The trace context and
+// the request id found in ctx are passed on to the orchestrator.
+func send(ctx context.Context, hc *http.Client, retry Retry, method, url string, body interface{}, header http.Header, want int) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+		header.Set("Content-Type", "application/json")
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		header.Set(logging.RequestIDHeader, id)
+	}
+	tracing.Inject(ctx, header)
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header.Clone()
+		resp, err := hc.Do(req)
+		if err == nil && resp.StatusCode == want {
+			return resp, nil
+		}
+
+		var wait time.Duration
+		if err == nil {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			err = &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+			if !retryable(resp.StatusCode) {
+				return nil, err
+			}
+			if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+		} else if ctx.Err() != nil {
+			return nil, err
+		}
+		if attempt >= retry.Attempts {
+			return nil, err
+		}
+
+		if wait == 0 {
+			wait = retry.backoff(attempt)
+		}
+		wait = min(wait, retry.MaxBackoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// drain reads what is left of the response body before closing it, so the
+// connection goes back to the pool and is reused by the next call.
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+}
+
+// backoff returns the pause before the attempt following the given one:
+// MinBackoff doubled for every earlier attempt, half of it random.
+func (r Retry) backoff(attempt int) time.Duration {
+	d := r.MinBackoff << (attempt - 1)
+	if d <= 0 || d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + mathrand.N(d/2)
+}
+
+func retryable(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
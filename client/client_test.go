@@ -0,0 +1,252 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/client"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+// TestMain keeps the logs of the tested code out of the test output.
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// compute plays the part of an agent until the context is done.
+func compute(ctx context.Context, ac *client.AgentClient) {
+	for ctx.Err() == nil {
+		task, err := ac.Fetch(ctx)
+		if err != nil {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		var result float64
+		switch task.Operation {
+		case "+":
+			result = task.Arg1 + task.Arg2
+		case "-":
+			result = task.Arg1 - task.Arg2
+		case "*":
+			result = task.Arg1 * task.Arg2
+		case "/":
+			result = task.Arg1 / task.Arg2
+		}
+		ac.Submit(ctx, client.TaskResult{ID: task.ID, Result: result, OperationTime: time.Millisecond})
+	}
+}
+
+// newOrchestrator builds an orchestrator configured by the environment of
+// the test. Agents name themselves, as if AGENT_AUTH=none was set.
+func newOrchestrator(t testing.TB) *orchestrator.Orchestrator {
+	t.Helper()
+	o, err := orchestrator.NewOrchestrator(config.FromEnv())
+	if err != nil {
+		t.Fatalf("failed to create the orchestrator: %v", err)
+	}
+	o.AnonymousAgents = true
+	return o
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	srv := httptest.NewServer(o.Router())
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL+"/", "")
+	if err := c.Register(ctx, "alice", "secret"); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if _, err := c.Login(ctx, "alice", "wrong"); !isStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("login with a wrong password: got %v", err)
+	}
+	if _, err := c.Login(ctx, "alice", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	if _, err := c.Submit(ctx, "2+"); !isStatus(err, http.StatusUnprocessableEntity) {
+		t.Fatalf("invalid expression: got %v", err)
+	}
+	cancelled, err := c.Submit(ctx, "1+1")
+	if err != nil {
+		t.Fatalf("failed to submit: %v", err)
+	}
+	expr, err := c.Cancel(ctx, cancelled)
+	if err != nil || expr.Status != client.StatusCancelled || !expr.Finished() {
+		t.Fatalf("invalid cancelled expression: %+v, %v", expr, err)
+	}
+	id, err := c.Submit(ctx, "2+2*3")
+	if err != nil {
+		t.Fatalf("failed to submit: %v", err)
+	}
+	tasks, err := c.Tasks(ctx, id)
+	if err != nil || len(tasks) != 2 || tasks[1].Deps[0] != tasks[0].ID || tasks[0].Status != client.TaskUntouched {
+		t.Fatalf("invalid tasks: %+v, %v", tasks, err)
+	}
+
+	agentCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go compute(agentCtx, client.NewAgentClient(srv.URL, "agent-1", ""))
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	expr, err = c.Wait(waitCtx, id, 5*time.Millisecond)
+	if err != nil || expr.Status != client.StatusResolved || expr.Result != 8 || expr.Tasks["resolved"] != 2 {
+		t.Fatalf("invalid resolved expression: %+v, %v", expr, err)
+	}
+
+	list, err := c.List(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != cancelled || list[1].ID != id {
+		t.Fatalf("invalid expressions: %+v, %v", list, err)
+	}
+	if _, err := c.Get(ctx, 99); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("unknown expression: got %v", err)
+	}
+	c.Token = ""
+	if _, err := c.List(ctx); !isStatus(err, http.StatusUnauthorized) {
+		t.Errorf("request without a token: got %v", err)
+	}
+}
+
+func TestClientCosts(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.AdminToken = "admin-token"
+	srv := httptest.NewServer(o.Router())
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL, "")
+	for _, login := range []string{"admin", "alice"} {
+		if err := c.Register(ctx, login, "secret"); err != nil {
+			t.Fatalf("failed to register %s: %v", login, err)
+		}
+	}
+	if _, err := c.Login(ctx, "alice", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if _, err := c.Costs(ctx); !isStatus(err, http.StatusForbidden) {
+		t.Fatalf("costs read by a user: got %v", err)
+	}
+	if _, err := c.Login(ctx, "admin", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if _, err := c.Costs(ctx); !isStatus(err, http.StatusForbidden) {
+		t.Fatalf("costs read by a user named admin: got %v", err)
+	}
+	c.Token = "admin-token"
+	costs, err := c.SetCosts(ctx, map[string]time.Duration{"*": 3 * time.Second})
+	if err != nil || costs["*"] != 3*time.Second || costs["+"] != time.Millisecond {
+		t.Fatalf("invalid costs: %v, %v", costs, err)
+	}
+	if costs, err = c.Costs(ctx); err != nil || costs["*"] != 3*time.Second || len(costs) != 4 {
+		t.Fatalf("invalid costs: %v, %v", costs, err)
+	}
+}
+
+func TestClientBatch(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	srv := httptest.NewServer(o.Router())
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL, "")
+	c.Register(ctx, "alice", "secret")
+	if _, err := c.Login(ctx, "alice", "secret"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	id, err := c.Submit(ctx, "(1+2)*(3+4)")
+	if err != nil {
+		t.Fatalf("failed to submit: %v", err)
+	}
+
+	ac := client.NewAgentClient(srv.URL, "agent-1", "")
+	tasks, err := ac.FetchBatch(ctx, 5)
+	if err != nil || len(tasks) != 3 {
+		t.Fatalf("invalid batch: %+v, %v", tasks, err)
+	}
+	results := []client.TaskResult{{ID: tasks[0].ID, Result: 3}, {ID: tasks[1].ID, Result: 7}, {ID: tasks[2].ID, Result: 21}, {ID: 99}}
+	errs, err := ac.SubmitBatch(ctx, results)
+	if err != nil || len(errs) != 4 || errs[0] != nil || errs[1] != nil || errs[2] != nil || !isStatus(errs[3], http.StatusNotFound) {
+		t.Fatalf("invalid outcome of the batch: %v, %v", errs, err)
+	}
+	if _, err := ac.FetchBatch(ctx, 5); err != client.ErrNoTask {
+		t.Errorf("empty queue: got %v want %v", err, client.ErrNoTask)
+	}
+	if expr, err := c.Get(ctx, id); err != nil || expr.Result != 21 {
+		t.Errorf("invalid expression: %+v, %v", expr, err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	router := o.Router()
+	token, _ := o.Auth.Issue("alice")
+
+	// The first two answers are lost on the way back, as if a proxy failed
+	// after the orchestrator handled the request.
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n > 2 {
+			router.ServeHTTP(w, r)
+			return
+		}
+		router.ServeHTTP(httptest.NewRecorder(), r)
+		if n == 1 {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL, token)
+	c.Retry = client.Retry{Attempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	id, err := c.Submit(context.Background(), "2+2")
+	if err != nil || id != 1 {
+		t.Fatalf("failed to submit: id %d, %v", id, err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("invalid number of attempts: got %d want 3", got)
+	}
+	if len(o.Exprs) != 1 {
+		t.Errorf("a retried submission created %d expressions", len(o.Exprs))
+	}
+
+	calls.Store(0)
+	c.Retry.Attempts = 2
+	if _, err := c.Submit(context.Background(), "3+3"); !isStatus(err, http.StatusServiceUnavailable) {
+		t.Errorf("expected the error of the last attempt, got %v", err)
+	}
+
+	calls.Store(0)
+	c.Retry.Attempts = 1
+	if _, err := c.Submit(context.Background(), "3+3"); !isStatus(err, http.StatusBadGateway) {
+		t.Errorf("expected no retries, got %v", err)
+	}
+}
+
+func isStatus(err error, code int) bool {
+	var statusErr *client.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == code
+}
@@ -0,0 +1,103 @@
+package client
+
+import "time"
+
+// Statuses of expressions and tasks.
+const (
+	StatusNotResolved = "not resolved"
+	StatusResolved    = "resolved"
+	StatusCancelled   = "cancelled"
+	StatusFailed      = "failed"
+
+	TaskUntouched = "untouched"
+	TaskLeased    = "solved"
+	TaskResolved  = "resolved"
+	TaskCancelled = "cancelled"
+	TaskFailed    = "failed"
+)
+
+// Precision modes of expressions. Big expressions are computed only by
+// agents advertising PrecisionBig.
+const (
+	PrecisionFloat = "float"
+	PrecisionBig   = "big"
+)
+
+// Expression is the state of a submitted expression. Tasks counts its tasks
+// by status, with the "total" key holding the number of all tasks. Error
+// tells why a failed expression failed.
+type Expression struct {
+	ID               int            `json:"id"`
+	Status           string         `json:"status"`
+	Result           float64        `json:"result"`
+	Body             string         `json:"body"`
+	CreatedAt        time.Time      `json:"created_at"`
+	StartedAt        *time.Time     `json:"started_at,omitempty"`
+	FinishedAt       *time.Time     `json:"finished_at,omitempty"`
+	ComputeTime      time.Duration  `json:"compute_time"`
+	CriticalPathTime time.Duration  `json:"critical_path_time"`
+	Tasks            map[string]int `json:"tasks"`
+	Precision        string         `json:"precision,omitempty"`
+	Error            string         `json:"error,omitempty"`
+}
+
+// Finished reports whether the expression will not change anymore.
+func (e Expression) Finished() bool {
+	return e.Status == StatusResolved || e.Status == StatusCancelled || e.Status == StatusFailed
+}
+
+// ExpressionTask is one operation of an expression. Deps lists the tasks
+// whose results it waits for.
+type ExpressionTask struct {
+	ID            int           `json:"id"`
+	Operation     string        `json:"operation"`
+	Arg1          float64       `json:"arg1"`
+	Arg2          float64       `json:"arg2"`
+	Deps          []int         `json:"deps"`
+	Status        string        `json:"status"`
+	Result        float64       `json:"result"`
+	Agent         string        `json:"agent,omitempty"`
+	OperationTime time.Duration `json:"operation_time"`
+	QueuedAt      time.Time     `json:"queued_at"`
+	DispatchedAt  *time.Time    `json:"dispatched_at,omitempty"`
+}
+
+// Function is a user function. Digest identifies its module.
+type Function struct {
+	Name      string    `json:"name"`
+	Digest    string    `json:"digest"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Task is an operation handed out to an agent.
+type Task struct {
+	ID            int           `json:"id"`
+	ExpressionID  int           `json:"expression_id"`
+	Arg1          float64       `json:"arg1"`
+	Arg2          float64       `json:"arg2"`
+	Operation     string        `json:"operation"`
+	OperationTime time.Duration `json:"operation_time"`
+	Precision     string        `json:"precision"`
+	// Function is the digest of the module of a user function called
+	// Operation, which Module downloads.
+	Function string `json:"function,omitempty"`
+	// BigArg1 and BigArg2 are the arguments of a big precision task computed
+	// by other tasks, in full precision. Arg1 and Arg2 hold them rounded.
+	BigArg1 string `json:"big_arg1,omitempty"`
+	BigArg2 string `json:"big_arg2,omitempty"`
+
+	traceparent string
+}
+
+// TaskResult is the outcome of a task computed by an agent. Error is set
+// instead of Result when the task cannot be computed, which fails its
+// expression.
+type TaskResult struct {
+	ID            int           `json:"id"`
+	Result        float64       `json:"result"`
+	OperationTime time.Duration `json:"operation_time"`
+	Error         string        `json:"error,omitempty"`
+	// BigResult is the result of a big precision task in full precision,
+	// in decimal.
+	BigResult string `json:"big_result,omitempty"`
+}
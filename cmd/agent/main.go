@@ -1,17 +1,52 @@
 package main
 
 import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tracing"
 	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/agent"
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		slog.Error("the configuration was not loaded", "error", err)
+		os.Exit(2)
+	}
+	logging.Setup(cfg.OneOf("LOG_FORMAT", "text", "text", "json"), cfg.OneOf("LOG_LEVEL", "info", "debug", "info", "warn", "error"))
+	exporter := cfg.OneOf("TRACE_EXPORTER", "", "", "stdout", "file")
+	traceFile := cfg.String("TRACE_FILE", "traces.json")
+	a, err := agent.NewAgent(cfg)
+	if err != nil {
+		slog.Error("the configuration is invalid", "error", err)
+		os.Exit(2)
+	}
+	cfg.Log(slog.Default())
+
+	shutdown, err := tracing.Setup("agent", exporter, traceFile)
+	if err != nil {
+		slog.Error("tracing was not set up", "error", err)
+	} else {
+		defer shutdown(context.Background())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		agent.NewAgent().Run()
+		if err := a.Run(ctx); err != nil {
+			slog.Error("the agent stopped with an error", "error", err)
+		}
 	}()
 	wg.Wait()
 }
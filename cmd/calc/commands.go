@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/client"
+)
+
+func register(ctx context.Context, e *env, args []string) error {
+	login := e.flags.String("login", "", "user login")
+	password := e.flags.String("password", "", "user password")
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	if err := e.client.Register(ctx, *login, *password); err != nil {
+		return err
+	}
+	fmt.Printf("user %s was registered\n", *login)
+	return nil
+}
+
+func login(ctx context.Context, e *env, args []string) error {
+	login := e.flags.String("login", "", "user login")
+	password := e.flags.String("password", "", "user password")
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	token, err := e.client.Login(ctx, *login, *password)
+	if err != nil {
+		return err
+	}
+	if e.output == "json" {
+		return printJSON(map[string]string{"token": token})
+	}
+	fmt.Println(token)
+	return nil
+}
+
+func submit(ctx context.Context, e *env, args []string) error {
+	wait := e.flags.Bool("wait", false, "wait until the expressions are resolved")
+	file := e.flags.String("f", "", "file with one expression per line, - for the standard input")
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	exprs, err := readExpressions(e.flags.Args(), *file)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int, 0, len(exprs))
+	for _, expr := range exprs {
+		id, err := e.client.Submit(ctx, expr)
+		if err != nil {
+			return fmt.Errorf("%s: %w", expr, err)
+		}
+		ids = append(ids, id)
+	}
+	if !*wait {
+		if e.output == "json" {
+			resp := make([]map[string]int, len(ids))
+			for i, id := range ids {
+				resp[i] = map[string]int{"id": id}
+			}
+			return printJSON(resp)
+		}
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+		return nil
+	}
+
+	resolved := make([]client.Expression, 0, len(ids))
+	for _, id := range ids {
+		expr, err := e.client.Wait(ctx, id, e.interval)
+		if err != nil {
+			return err
+		}
+		resolved = append(resolved, expr)
+	}
+	return e.printExpressions(resolved)
+}
+
+// readExpressions takes the expressions from the arguments, or else from the
+// file, or else from the standard input. Empty lines are skipped.
+func readExpressions(args []string, file string) ([]string, error) {
+	if len(args) > 0 && file == "" && !(len(args) == 1 && args[0] == "-") {
+		return args, nil
+	}
+	var r io.Reader = os.Stdin
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var exprs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			exprs = append(exprs, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(exprs) == 0 {
+		return nil, fmt.Errorf("no expressions to submit")
+	}
+	return exprs, nil
+}
+
+func get(ctx context.Context, e *env, args []string) error {
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	ids, err := parseIDs(e.flags.Args())
+	if err != nil {
+		return err
+	}
+	exprs := make([]client.Expression, 0, len(ids))
+	for _, id := range ids {
+		expr, err := e.client.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("expression %d: %w", id, err)
+		}
+		exprs = append(exprs, expr)
+	}
+	return e.printExpressions(exprs)
+}
+
+func list(ctx context.Context, e *env, args []string) error {
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	exprs, err := e.client.List(ctx)
+	if err != nil {
+		return err
+	}
+	return e.printExpressions(exprs)
+}
+
+func cancel(ctx context.Context, e *env, args []string) error {
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	ids, err := parseIDs(e.flags.Args())
+	if err != nil {
+		return err
+	}
+	exprs := make([]client.Expression, 0, len(ids))
+	for _, id := range ids {
+		expr, err := e.client.Cancel(ctx, id)
+		if err != nil {
+			return fmt.Errorf("expression %d: %w", id, err)
+		}
+		exprs = append(exprs, expr)
+	}
+	return e.printExpressions(exprs)
+}
+
+func tasks(ctx context.Context, e *env, args []string) error {
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	ids, err := parseIDs(e.flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return fmt.Errorf("tasks takes exactly one expression id")
+	}
+	tasks, err := e.client.Tasks(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	return e.printTasks(tasks)
+}
+
+// watch prints the progress of the expression every time it changes and
+// stops once the expression is resolved or cancelled.
+func watch(ctx context.Context, e *env, args []string) error {
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	ids, err := parseIDs(e.flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return fmt.Errorf("watch takes exactly one expression id")
+	}
+
+	last := ""
+	for {
+		expr, err := e.client.Get(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		if line := progress(expr); line != last {
+			last = line
+			if e.output == "json" {
+				if err := printJSON(expr); err != nil {
+					return err
+				}
+			} else {
+				fmt.Println(line)
+			}
+		}
+		if expr.Finished() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+func costs(ctx context.Context, e *env, args []string) error {
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	if e.flags.NArg() == 0 {
+		costs, err := e.client.Costs(ctx)
+		if err != nil {
+			return err
+		}
+		return e.printCosts(costs)
+	}
+	changes := make(map[string]time.Duration, e.flags.NArg())
+	for _, arg := range e.flags.Args() {
+		op, value, ok := strings.Cut(arg, "=")
+		ms, err := strconv.Atoi(value)
+		if !ok || err != nil || ms < 0 {
+			return fmt.Errorf("invalid cost %q, expected operation=milliseconds", arg)
+		}
+		changes[op] = time.Duration(ms) * time.Millisecond
+	}
+	costs, err := e.client.SetCosts(ctx, changes)
+	if err != nil {
+		return err
+	}
+	return e.printCosts(costs)
+}
+
+func parseIDs(args []string) ([]int, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("an expression id is required")
+	}
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid expression id %q", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
@@ -0,0 +1,112 @@
+// Command calc is a command-line client of the distributed calculator.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/client"
+)
+
+const usage = `usage: calc <command> [flags] [arguments]
+
+commands:
+  register  -login L -password P   create a user
+  login     -login L -password P   print a token for CALC_TOKEN
+  submit    [-wait] [-f file] [expression ...]
+            send expressions from the arguments, a file or the standard input
+  get       id ...                 show expressions
+  list                             show all expressions
+  cancel    id ...                 stop computing expressions
+  tasks     id                     show the tasks of an expression
+  watch     id                     follow an expression until it is resolved
+  repl      [-history file]        interactive shell, history in CALC_HISTORY
+  costs     [op=ms ...]            show or change operation times (-token ADMIN_TOKEN)
+
+common flags:
+  -server   orchestrator address, CALC_SERVER, default http://localhost:8080
+  -token    user token, CALC_TOKEN
+  -o        output format: table or json, default table
+`
+
+// command is a subcommand of the client. It gets the environment with the
+// common flags registered, so it can add flags of its own before parsing.
+type command func(ctx context.Context, env *env, args []string) error
+
+var commands = map[string]command{
+	"register": register,
+	"login":    login,
+	"submit":   submit,
+	"get":      get,
+	"list":     list,
+	"cancel":   cancel,
+	"tasks":    tasks,
+	"watch":    watch,
+	"repl":     repl,
+	"costs":    costs,
+}
+
+// env holds what every command needs: the parsed common flags and the
+// client built from them.
+type env struct {
+	flags    *flag.FlagSet
+	server   string
+	token    string
+	output   string
+	interval time.Duration
+	client   *client.Client
+}
+
+func newEnv(name string) *env {
+	e := &env{flags: flag.NewFlagSet(name, flag.ContinueOnError)}
+	server := os.Getenv("CALC_SERVER")
+	if server == "" {
+		server = "http://localhost:8080"
+	}
+	e.flags.StringVar(&e.server, "server", server, "orchestrator address")
+	e.flags.StringVar(&e.token, "token", os.Getenv("CALC_TOKEN"), "user token")
+	e.flags.StringVar(&e.output, "o", "table", "output format: table or json")
+	e.flags.DurationVar(&e.interval, "interval", 500*time.Millisecond, "polling interval of -wait and watch")
+	e.flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	return e
+}
+
+// parse parses the flags and creates the client.
+func (e *env) parse(args []string) error {
+	if err := e.flags.Parse(args); err != nil {
+		return err
+	}
+	if e.output != "table" && e.output != "json" {
+		return fmt.Errorf("unknown output format %q", e.output)
+	}
+	e.client = client.New(e.server, e.token)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cmd(ctx, newEnv(os.Args[1]), os.Args[2:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "calc:", err)
+		os.Exit(1)
+	}
+}
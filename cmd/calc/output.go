@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/client"
+)
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (e *env) printExpressions(exprs []client.Expression) error {
+	if e.output == "json" {
+		return printJSON(exprs)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tRESULT\tTASKS\tEXPRESSION")
+	for _, expr := range exprs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d/%d\t%s\n", expr.ID, expr.Status, result(expr), expr.Tasks["resolved"], expr.Tasks["total"], expr.Body)
+	}
+	return w.Flush()
+}
+
+func (e *env) printTasks(tasks []client.ExpressionTask) error {
+	if e.output == "json" {
+		return printJSON(tasks)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOPERATION\tDEPS\tSTATUS\tRESULT\tAGENT")
+	for _, task := range tasks {
+		deps := make([]string, len(task.Deps))
+		for i, dep := range task.Deps {
+			deps[i] = strconv.Itoa(dep)
+		}
+		res := "-"
+		if task.Status == "resolved" {
+			res = formatFloat(task.Result)
+		}
+		agent := task.Agent
+		if agent == "" {
+			agent = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s %s %s\t%s\t%s\t%s\t%s\n", task.ID, formatFloat(task.Arg1), task.Operation, formatFloat(task.Arg2),
+			strings.Join(deps, ","), task.Status, res, agent)
+	}
+	return w.Flush()
+}
+
+func (e *env) printCosts(costs map[string]time.Duration) error {
+	if e.output == "json" {
+		return printJSON(costs)
+	}
+	ops := make([]string, 0, len(costs))
+	for op := range costs {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tTIME")
+	for _, op := range ops {
+		cost := "agent"
+		if costs[op] > 0 {
+			cost = costs[op].String()
+		}
+		fmt.Fprintf(w, "%s\t%s\n", op, cost)
+	}
+	return w.Flush()
+}
+
+// progress describes the state of the expression in one line.
+func progress(expr client.Expression) string {
+	line := fmt.Sprintf("expression %d: %s, %d/%d tasks resolved", expr.ID, expr.Status, expr.Tasks["resolved"], expr.Tasks["total"])
+	if expr.Status == "resolved" {
+		line += ", result " + formatFloat(expr.Result)
+	}
+	return line
+}
+
+func result(expr client.Expression) string {
+	if expr.Status != "resolved" {
+		return "-"
+	}
+	return formatFloat(expr.Result)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/client"
+)
+
+const replHelp = `enter an expression to compute it, ans is the last result and $N the result
+of the N-th expression of the session, for example: $1*(ans-2)
+
+commands:
+  history  show the previous lines
+  help     show this text
+  exit     leave the shell, so does Ctrl+D
+
+Ctrl+C cancels the expression being computed
+`
+
+// maxHistory bounds the number of lines kept in the history file.
+const maxHistory = 1000
+
+var reference = regexp.MustCompile(`\$(\d+)|\bans\b`)
+
+// session holds the results of the expressions computed in the shell.
+type session struct {
+	results []float64
+	history []string
+	file    string
+}
+
+func repl(ctx context.Context, e *env, args []string) error {
+	historyFile := e.flags.String("history", historyPath(), "file keeping the history between sessions")
+	if err := e.parse(args); err != nil {
+		return err
+	}
+	s := &session{file: *historyFile}
+	s.loadHistory()
+
+	// Interrupts cancel the expression being computed instead of stopping
+	// the shell.
+	signal.Reset(os.Interrupt)
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	fmt.Println(`calculator shell, type "help" for help`)
+	for {
+		fmt.Print("calc> ")
+		var line string
+		var ok bool
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return nil
+		case <-interrupts:
+			fmt.Println()
+			continue
+		case line, ok = <-lines:
+		}
+		if !ok {
+			fmt.Println()
+			return nil
+		}
+		line = strings.TrimSpace(line)
+		switch line {
+		case "":
+			continue
+		case "exit", "quit":
+			return nil
+		case "help":
+			fmt.Print(replHelp)
+			continue
+		case "history":
+			for i, entry := range s.history {
+				fmt.Printf("%5d  %s\n", i+1, entry)
+			}
+			continue
+		}
+		s.addHistory(line)
+
+		expr, err := s.expand(line)
+		if err != nil {
+			fmt.Println("error:", err)
+			continue
+		}
+		evalCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-interrupts:
+				cancel()
+			case <-evalCtx.Done():
+			}
+		}()
+		result, err := evaluate(evalCtx, e, expr)
+		cancel()
+		if err != nil {
+			fmt.Println("error:", err)
+			continue
+		}
+		s.results = append(s.results, result)
+		fmt.Printf("$%d = %s\n", len(s.results), formatFloat(result))
+	}
+}
+
+// expand replaces the references to previous results with their values. The
+// values are written without an exponent, which the parser does not accept.
+func (s *session) expand(line string) (string, error) {
+	var err error
+	expr := reference.ReplaceAllStringFunc(line, func(ref string) string {
+		n := len(s.results)
+		if ref != "ans" {
+			n, _ = strconv.Atoi(ref[1:])
+		}
+		if n < 1 || n > len(s.results) {
+			if err == nil {
+				err = fmt.Errorf("there is no result %s", ref)
+			}
+			return ref
+		}
+		result := s.results[n-1]
+		if math.IsInf(result, 0) || math.IsNaN(result) {
+			if err == nil {
+				err = fmt.Errorf("the result %s is not a finite number", ref)
+			}
+			return ref
+		}
+		value := strconv.FormatFloat(result, 'f', -1, 64)
+		if result < 0 {
+			value = "(" + value + ")"
+		}
+		return value
+	})
+	return expr, err
+}
+
+// evaluate submits the expression and prints its tasks as they are resolved.
+// When ctx is cancelled the expression is cancelled on the orchestrator too.
+func evaluate(ctx context.Context, e *env, expr string) (float64, error) {
+	id, err := e.client.Submit(ctx, expr)
+	if err != nil {
+		return 0, err
+	}
+	shown := make(map[int]bool)
+	for {
+		tasks, err := e.client.Tasks(ctx, id)
+		if err == nil {
+			for _, task := range tasks {
+				if task.Status == client.TaskResolved && !shown[task.ID] {
+					shown[task.ID] = true
+					fmt.Printf("  task %d: %s %s %s = %s\n", task.ID, formatFloat(task.Arg1), task.Operation, formatFloat(task.Arg2), formatFloat(task.Result))
+				}
+			}
+		}
+		var res client.Expression
+		if err == nil {
+			res, err = e.client.Get(ctx, id)
+		}
+		if ctx.Err() != nil {
+			cancelCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			e.client.Cancel(cancelCtx, id)
+			return 0, errors.New("the expression was cancelled")
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch res.Status {
+		case client.StatusResolved:
+			return res.Result, nil
+		case client.StatusCancelled:
+			return 0, errors.New("the expression was cancelled")
+		case client.StatusFailed:
+			return 0, fmt.Errorf("the expression failed: %s", res.Error)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+func historyPath() string {
+	if path := os.Getenv("CALC_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".calc_history")
+}
+
+func (s *session) loadHistory() {
+	if s.file == "" {
+		return
+	}
+	f, err := os.Open(s.file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			s.history = append(s.history, line)
+		}
+	}
+	if len(s.history) > maxHistory {
+		s.history = s.history[len(s.history)-maxHistory:]
+		os.WriteFile(s.file, []byte(strings.Join(s.history, "\n")+"\n"), 0o600)
+	}
+}
+
+// addHistory remembers the line and appends it to the history file, so it
+// survives the session even if the shell is killed. The file is trimmed to
+// maxHistory lines when it is loaded next time.
+func (s *session) addHistory(line string) {
+	s.history = append(s.history, line)
+	if len(s.history) > maxHistory {
+		s.history = s.history[len(s.history)-maxHistory:]
+	}
+	if s.file == "" {
+		return
+	}
+	f, err := os.OpenFile(s.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
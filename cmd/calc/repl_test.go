@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	t.Parallel()
+
+	s := &session{results: []float64{2, -3.5, 1e21, 1e-7, math.Inf(1)}}
+	testCases := []struct {
+		name     string
+		line     string
+		expected string
+		err      bool
+	}{
+		{"no references", "1+2", "1+2", false},
+		{"last result", "ans*2", "ans*2", true},
+		{"numbered results", "$1+$2", "2+(-3.5)", false},
+		{"large result", "$3+1", "1000000000000000000000+1", false},
+		{"small result", "$4*2", "0.0000001*2", false},
+		{"missing result", "$6+$1", "$6+2", true},
+		{"zero reference", "$0", "$0", true},
+		{"infinite result", "$5-1", "$5-1", true},
+		{"identifier with ans", "answer+1", "answer+1", false},
+	}
+	for _, ts := range testCases {
+		t.Run(ts.name, func(t *testing.T) {
+			expr, err := s.expand(ts.line)
+			if (err != nil) != ts.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && expr != ts.expected {
+				t.Errorf("invalid expansion: got %q want %q", expr, ts.expected)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "history")
+	lines := make([]string, 0, maxHistory+5)
+	for i := 1; i <= maxHistory+5; i++ {
+		lines = append(lines, "1+"+strconv.Itoa(i))
+	}
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n\n"), 0o600); err != nil {
+		t.Fatalf("failed to write the history: %v", err)
+	}
+
+	// Only the last maxHistory lines are kept, in the file too.
+	s := &session{file: file}
+	s.loadHistory()
+	if len(s.history) != maxHistory || s.history[0] != "1+6" || s.history[maxHistory-1] != lines[len(lines)-1] {
+		t.Fatalf("invalid history: %d lines from %q to %q", len(s.history), s.history[0], s.history[len(s.history)-1])
+	}
+	data, _ := os.ReadFile(file)
+	if got := strings.Count(string(data), "\n"); got != maxHistory {
+		t.Errorf("the file is not trimmed: got %d lines want %d", got, maxHistory)
+	}
+
+	s.addHistory("2*2")
+	if len(s.history) != maxHistory || s.history[0] != "1+7" || s.history[maxHistory-1] != "2*2" {
+		t.Errorf("invalid history after a line was added: %d lines from %q to %q", len(s.history), s.history[0], s.history[len(s.history)-1])
+	}
+	data, _ = os.ReadFile(file)
+	if !strings.HasSuffix(string(data), "\n2*2\n") {
+		t.Errorf("the line is not appended to the file")
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type contextKey struct{}
+
+// MaxPasswordBytes is the longest password bcrypt can hash.
+const MaxPasswordBytes = 72
+
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// dummyHash is compared with the passwords of logins that do not exist.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// CheckPassword reports whether the password matches the hash. A nil hash,
+// for a login that does not exist, is compared with a fixed hash and never
+// matches, so the answer takes as long as for an existing login and does not
+// tell which logins exist.
+func CheckPassword(hash []byte, password string) bool {
+	if hash == nil {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// Issuer signs and verifies the JWTs handed out to users on login.
+type Issuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewIssuer(secret []byte, ttl time.Duration) *Issuer {
+	return &Issuer{secret: secret, ttl: ttl}
+}
+
+// Issue returns a signed token for the user with the given login.
+func (i *Issuer) Issue(login string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   login,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(i.ttl)),
+	})
+	return token.SignedString(i.secret)
+}
+
+// Parse verifies the token and returns the login of its owner.
+func (i *Issuer) Parse(tokenString string) (string, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+		return i.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || claims.Subject == "" {
+		return "", errors.ErrUnauthorized
+	}
+	return claims.Subject, nil
+}
+
+// Middleware rejects requests without a valid bearer token and stores the
+// login of the token owner in the request context.
+func (i *Issuer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			http.Error(w, errors.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+		login, err := i.Parse(tokenString)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), login)))
+	})
+}
+
+func WithUser(ctx context.Context, login string) context.Context {
+	return context.WithValue(ctx, contextKey{}, login)
+}
+
+// UserFrom returns the login stored in the context, or an empty string for
+// anonymous requests.
+func UserFrom(ctx context.Context) string {
+	login, _ := ctx.Value(contextKey{}).(string)
+	return login
+}
@@ -0,0 +1,116 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+)
+
+func TestIssuer(t *testing.T) {
+	t.Parallel()
+
+	issuer := auth.NewIssuer([]byte("secret"), time.Hour)
+	token, err := issuer.Issue("alice")
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		issuer        *auth.Issuer
+		token         string
+		expectedLogin string
+		expectedError bool
+	}{
+		{"valid token", issuer, token, "alice", false},
+		{"foreign secret", auth.NewIssuer([]byte("other"), time.Hour), token, "", true},
+		{"tampered token", issuer, token + "x", "", true},
+		{"garbage", issuer, "garbage", "", true},
+	}
+	for _, ts := range testCases {
+		ts := ts
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+
+			login, err := ts.issuer.Parse(ts.token)
+			if (err != nil) != ts.expectedError {
+				t.Fatalf("invalid error: got %v", err)
+			}
+			if login != ts.expectedLogin {
+				t.Fatalf("invalid login: got %v want %v", login, ts.expectedLogin)
+			}
+		})
+	}
+
+	t.Run("expired token", func(t *testing.T) {
+		t.Parallel()
+
+		expired := auth.NewIssuer([]byte("secret"), -time.Minute)
+		token, _ := expired.Issue("alice")
+		if _, err := expired.Parse(token); err == nil {
+			t.Fatalf("expired token was accepted")
+		}
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	issuer := auth.NewIssuer([]byte("secret"), time.Hour)
+	token, _ := issuer.Issue("alice")
+	handler := issuer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(auth.UserFrom(r.Context())))
+	}))
+
+	testCases := []struct {
+		name               string
+		header             string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"valid token", "Bearer " + token, http.StatusOK, "alice"},
+		{"missing token", "", http.StatusUnauthorized, ""},
+		{"invalid token", "Bearer invalid", http.StatusUnauthorized, ""},
+	}
+	for _, ts := range testCases {
+		ts := ts
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if ts.header != "" {
+				r.Header.Set("Authorization", ts.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != ts.expectedStatusCode {
+				t.Fatalf("invalid status code: got %v want %v", w.Code, ts.expectedStatusCode)
+			}
+			if ts.expectedBody != "" && w.Body.String() != ts.expectedBody {
+				t.Fatalf("invalid user: got %v want %v", w.Body.String(), ts.expectedBody)
+			}
+		})
+	}
+}
+
+func TestPassword(t *testing.T) {
+	t.Parallel()
+
+	hash, err := auth.HashPassword("qwerty")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if !auth.CheckPassword(hash, "qwerty") {
+		t.Fatalf("valid password was rejected")
+	}
+	if auth.CheckPassword(hash, "qwerty1") {
+		t.Fatalf("invalid password was accepted")
+	}
+	if auth.CheckPassword(nil, "qwerty") {
+		t.Fatalf("a password of an unknown login was accepted")
+	}
+}
@@ -0,0 +1,81 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/cache"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	c := cache.New[string, int](2, time.Hour)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("invalid value: got %v, %v want 1, true", v, ok)
+	}
+	c.Set("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("least recently used entry was not evicted")
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Fatalf("invalid value: got %v, %v want 3, true", v, ok)
+	}
+
+	stats := c.Stats()
+	want := cache.Stats{Hits: 2, Misses: 1, Evictions: 1, Size: 2, Capacity: 2}
+	if stats != want {
+		t.Fatalf("invalid stats: got %+v want %+v", stats, want)
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	t.Parallel()
+
+	c := cache.New[string, int](2, -time.Second)
+	c.Set("a", 1)
+	if _, ok := c.Get("a"); ok {
+		t.Fatalf("expired entry was returned")
+	}
+	if stats := c.Stats(); stats.Size != 0 {
+		t.Fatalf("expired entry was not removed: size %d", stats.Size)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	t.Parallel()
+
+	c := cache.New[string, int](0, time.Hour)
+	c.Set("a", 1)
+	if _, ok := c.Get("a"); ok {
+		t.Fatalf("disabled cache returned a value")
+	}
+}
+
+func TestCachePeek(t *testing.T) {
+	t.Parallel()
+
+	c := cache.New[string, int](2, time.Hour)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if v, ok := c.Peek("a"); !ok || v != 1 {
+		t.Fatalf("invalid value: got %v, %v want 1, true", v, ok)
+	}
+	if _, ok := c.Peek("c"); ok {
+		t.Fatalf("missing entry was returned")
+	}
+	if stats := c.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("peeks were counted: %+v", stats)
+	}
+	// A peek uses the entry like Get does.
+	c.Set("c", 3)
+	if _, ok := c.Peek("b"); ok {
+		t.Fatalf("least recently used entry was not evicted")
+	}
+	c.Record(2, 1)
+	if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("invalid stats: %+v", stats)
+	}
+}
@@ -0,0 +1,405 @@
+package config
+
+import (
+	stderrors "errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Sources of a setting, from the lowest priority to the highest.
+const (
+	SourceDefault = "default"
+	SourceEnvFile = "env file"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// EnvFile is the file of environment variables Load reads the shipped
+// settings from.
+const EnvFile = "variables.env"
+
+// Config resolves settings named like environment variables. A value is taken
+// from the first layer that has it: command-line flags, the environment, the
+// config file, the env file, and finally the default given by the caller.
+// Invalid values are collected instead of being replaced by defaults and are
+// reported by Err.
+type Config struct {
+	path     string
+	file     map[string]string
+	envFile  map[string]string
+	flags    map[string]string
+	settings map[string]Setting
+	// known holds the settings read from the config this one was reloaded
+	// from, so their flags do not count as unknown.
+	known map[string]bool
+	errs  []error
+}
+
+// Setting is the effective value of a setting and the layer it came from.
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+	secret bool
+}
+
+// FromEnv returns a config with no file and no flags, which reads the
+// settings from the environment only.
+func FromEnv() *Config {
+	return &Config{
+		file:     map[string]string{},
+		envFile:  map[string]string{},
+		flags:    map[string]string{},
+		settings: map[string]Setting{},
+		known:    map[string]bool{},
+	}
+}
+
+// Load reads EnvFile, parses the command-line arguments and reads the YAML
+// or TOML file named by the -config flag or by CONFIG_FILE. Flags are written
+// as -time-addition-ms=100 or -time-addition-ms 100 and set the setting
+// TIME_ADDITION_MS.
+func Load(args []string) (*Config, error) {
+	return LoadEnvFile(EnvFile, args)
+}
+
+// LoadEnvFile is Load reading the env file at envPath. The env file is
+// optional. Its values are kept apart from the environment, below the config
+// file, since it lists the shipped settings that the file is meant to
+// change.
+func LoadEnvFile(envPath string, args []string) (*Config, error) {
+	c := FromEnv()
+	if env, err := godotenv.Read(envPath); err == nil {
+		c.envFile = env
+	} else if !stderrors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", envPath, err)
+	}
+	if err := c.parseFlags(args); err != nil {
+		return nil, err
+	}
+	path, ok := c.flags["CONFIG"]
+	if ok {
+		delete(c.flags, "CONFIG")
+	} else if path = os.Getenv("CONFIG_FILE"); path == "" {
+		path = c.envFile["CONFIG_FILE"]
+	}
+	if path != "" {
+		file, err := ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c.path, c.file = path, file
+	}
+	return c, nil
+}
+
+// Path returns the config file, or an empty string when there is none.
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Reload reads the config file again and returns a config with the new file
+// and the same flags. The environment and the env file are not reloaded.
+func (c *Config) Reload() (*Config, error) {
+	reloaded := FromEnv()
+	reloaded.path, reloaded.envFile, reloaded.flags = c.path, c.envFile, c.flags
+	for key := range c.settings {
+		reloaded.known[key] = true
+	}
+	for key := range c.known {
+		reloaded.known[key] = true
+	}
+	if c.path != "" {
+		file, err := ReadFile(c.path)
+		if err != nil {
+			return nil, err
+		}
+		reloaded.file = file
+	}
+	return reloaded, nil
+}
+
+func (c *Config) parseFlags(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name := strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "-") || name == "" || len(arg)-len(name) > 2 {
+			return fmt.Errorf("%w: unexpected argument %q", errors.ErrVariableValue, arg)
+		}
+		name, value, ok := strings.Cut(name, "=")
+		if !ok {
+			if i+1 == len(args) {
+				return fmt.Errorf("%w: flag -%s has no value", errors.ErrVariableValue, name)
+			}
+			i++
+			value = args[i]
+		}
+		c.flags[Key(name)] = value
+	}
+	return nil
+}
+
+// Key converts a flag or file key such as time-addition-ms or
+// time_addition_ms to the name of the setting, TIME_ADDITION_MS.
+func Key(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// ReadFile reads a YAML (.yaml, .yml) or TOML (.toml) file. Nested tables are
+// flattened, so tls: {cert_file: a} sets TLS_CERT_FILE, and lists are joined
+// with commas.
+func ReadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("%w: unknown config file format %q", errors.ErrVariableValue, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	values := map[string]string{}
+	flatten("", raw, values)
+	return values, nil
+}
+
+func flatten(prefix string, raw map[string]interface{}, values map[string]string) {
+	for name, value := range raw {
+		key := Key(name)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flatten(key, v, values)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// lookup returns the raw value of the setting and its source. Empty values in
+// the environment and the env file count as unset, as in variables.env every
+// setting is listed whether it is used or not.
+func (c *Config) lookup(key string) (string, string) {
+	if v, ok := c.flags[key]; ok {
+		return v, SourceFlag
+	}
+	if v := os.Getenv(key); v != "" {
+		return v, SourceEnv
+	}
+	if v, ok := c.file[key]; ok {
+		return v, SourceFile
+	}
+	if v := c.envFile[key]; v != "" {
+		return v, SourceEnvFile
+	}
+	return "", SourceDefault
+}
+
+func (c *Config) set(key, value, source string) {
+	c.settings[key] = Setting{Key: key, Value: value, Source: source}
+}
+
+func (c *Config) invalid(key, source string) {
+	c.errs = append(c.errs, fmt.Errorf("%w: %s (%s)", errors.ErrVariableValue, key, source))
+}
+
+// String returns the value of the setting or def when it is not set.
+func (c *Config) String(key, def string) string {
+	v, source := c.lookup(key)
+	if source == SourceDefault {
+		v = def
+	}
+	c.set(key, v, source)
+	return v
+}
+
+// Secret is String for values that must not be printed.
+func (c *Config) Secret(key, def string) string {
+	v := c.String(key, def)
+	s := c.settings[key]
+	s.secret = true
+	c.settings[key] = s
+	return v
+}
+
+// OneOf returns the value of the setting, which must be one of the allowed
+// values compared case-insensitively.
+func (c *Config) OneOf(key, def string, allowed ...string) string {
+	v := c.String(key, def)
+	for _, a := range allowed {
+		if strings.EqualFold(v, a) {
+			return a
+		}
+	}
+	c.invalid(key, c.settings[key].Source)
+	return def
+}
+
+// Int returns the value of the setting, which must be an integer not less
+// than min.
+func (c *Config) Int(key string, def, min int) int {
+	v, source := c.lookup(key)
+	if source == SourceDefault {
+		c.set(key, strconv.Itoa(def), source)
+		return def
+	}
+	c.set(key, v, source)
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		c.invalid(key, source)
+		return def
+	}
+	return n
+}
+
+// Int64 is Int for values that may not fit in an int.
+func (c *Config) Int64(key string, def, min int64) int64 {
+	v, source := c.lookup(key)
+	if source == SourceDefault {
+		c.set(key, strconv.FormatInt(def, 10), source)
+		return def
+	}
+	c.set(key, v, source)
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < min {
+		c.invalid(key, source)
+		return def
+	}
+	return n
+}
+
+// Float returns the value of the setting, which must be a number not less
+// than min.
+func (c *Config) Float(key string, def, min float64) float64 {
+	v, source := c.lookup(key)
+	if source == SourceDefault {
+		c.set(key, strconv.FormatFloat(def, 'g', -1, 64), source)
+		return def
+	}
+	c.set(key, v, source)
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < min {
+		c.invalid(key, source)
+		return def
+	}
+	return n
+}
+
+// Bool returns the value of the setting, written as true or false.
+func (c *Config) Bool(key string, def bool) bool {
+	v, source := c.lookup(key)
+	if source == SourceDefault {
+		c.set(key, strconv.FormatBool(def), source)
+		return def
+	}
+	c.set(key, v, source)
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		c.invalid(key, source)
+		return def
+	}
+	return b
+}
+
+// Millis returns the value of a setting given in milliseconds, which must be
+// positive.
+func (c *Config) Millis(key string, def time.Duration) time.Duration {
+	return time.Duration(c.Int(key, int(def/time.Millisecond), 1)) * time.Millisecond
+}
+
+// Port returns the value of a setting holding a TCP port from 1 to 65535. An
+// empty def makes the port optional.
+func (c *Config) Port(key, def string) string {
+	v := c.String(key, def)
+	if v == "" && def == "" {
+		return ""
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > 65535 {
+		c.invalid(key, c.settings[key].Source)
+		return def
+	}
+	return v
+}
+
+// Invalid reports the setting as invalid, for checks the getters cannot do.
+func (c *Config) Invalid(key string) {
+	source := SourceDefault
+	if s, ok := c.settings[key]; ok {
+		source = s.Source
+	}
+	c.invalid(key, source)
+}
+
+// Err returns the invalid settings and the flags that no setting was read
+// from. It must be called after all the settings are read.
+func (c *Config) Err() error {
+	errs := c.errs
+	unknown := []string{}
+	for key := range c.flags {
+		if _, ok := c.settings[key]; !ok && !c.known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, fmt.Errorf("%w: unknown flag -%s", errors.ErrVariableValue, strings.ToLower(strings.ReplaceAll(key, "_", "-"))))
+	}
+	return stderrors.Join(errs...)
+}
+
+// Settings returns the settings read so far, sorted by key.
+func (c *Config) Settings() []Setting {
+	settings := make([]Setting, 0, len(c.settings))
+	for _, s := range c.settings {
+		if s.secret && s.Value != "" {
+			s.Value = "***"
+		}
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings
+}
+
+// Log writes the effective settings, hiding secrets. Values that are not
+// defaults are followed by their source.
+func (c *Config) Log(logger *slog.Logger) {
+	attrs := []any{}
+	for _, s := range c.Settings() {
+		v := s.Value
+		if s.Source != SourceDefault {
+			v += " (" + s.Source + ")"
+		}
+		attrs = append(attrs, s.Key, v)
+	}
+	logger.Info("effective configuration", attrs...)
+}
@@ -0,0 +1,223 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	calcerrors "github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+)
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLayers(t *testing.T) {
+	path := writeFile(t, "calc.yaml", `
+port: 8081
+time_addition_ms: 100
+time_subtraction_ms: 200
+time_multiplications_ms: 300
+tls:
+  cert_file: server.crt
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("TIME_SUBTRACTION_MS", "20")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "30")
+
+	cfg, err := config.Load([]string{"-time-multiplications-ms=3", "--computing-power", "4"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	testCases := []struct {
+		key            string
+		got            interface{}
+		expected       interface{}
+		expectedSource string
+	}{
+		{"PORT", cfg.Port("PORT", "8080"), "8081", config.SourceFile},
+		{"TIME_ADDITION_MS", cfg.Millis("TIME_ADDITION_MS", time.Millisecond), 100 * time.Millisecond, config.SourceFile},
+		{"TIME_SUBTRACTION_MS", cfg.Millis("TIME_SUBTRACTION_MS", time.Millisecond), 20 * time.Millisecond, config.SourceEnv},
+		{"TIME_MULTIPLICATIONS_MS", cfg.Millis("TIME_MULTIPLICATIONS_MS", time.Millisecond), 3 * time.Millisecond, config.SourceFlag},
+		{"TIME_DIVISIONS_MS", cfg.Millis("TIME_DIVISIONS_MS", time.Millisecond), time.Millisecond, config.SourceDefault},
+		{"COMPUTING_POWER", cfg.Int("COMPUTING_POWER", 1, 1), 4, config.SourceFlag},
+		{"TLS_CERT_FILE", cfg.String("TLS_CERT_FILE", ""), "server.crt", config.SourceFile},
+	}
+	if err := cfg.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sources := map[string]string{}
+	for _, s := range cfg.Settings() {
+		sources[s.Key] = s.Source
+	}
+	for _, ts := range testCases {
+		if ts.got != ts.expected {
+			t.Errorf("%s: got %v want %v", ts.key, ts.got, ts.expected)
+		}
+		if sources[ts.key] != ts.expectedSource {
+			t.Errorf("%s: got source %s want %s", ts.key, sources[ts.key], ts.expectedSource)
+		}
+	}
+}
+
+func TestEnvFile(t *testing.T) {
+	// The shipped variables.env lists every setting, and the config file
+	// must still change them.
+	envPath := writeFile(t, "variables.env", "PORT=8080\nTIME_ADDITION_MS=2000\nTIME_SUBTRACTION_MS=4000\nCOMPUTING_POWER=1\nTLS_CERT_FILE=\n")
+	path := writeFile(t, "calc.yaml", `
+port: 8081
+time_addition_ms: 100
+computing_power: 4
+`)
+	t.Setenv("TIME_SUBTRACTION_MS", "20")
+
+	cfg, err := config.LoadEnvFile(envPath, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	testCases := []struct {
+		key            string
+		got            interface{}
+		expected       interface{}
+		expectedSource string
+	}{
+		{"PORT", cfg.Port("PORT", "9000"), "8081", config.SourceFile},
+		{"TIME_ADDITION_MS", cfg.Millis("TIME_ADDITION_MS", time.Millisecond), 100 * time.Millisecond, config.SourceFile},
+		{"TIME_SUBTRACTION_MS", cfg.Millis("TIME_SUBTRACTION_MS", time.Millisecond), 20 * time.Millisecond, config.SourceEnv},
+		{"COMPUTING_POWER", cfg.Int("COMPUTING_POWER", 2, 1), 4, config.SourceFile},
+		{"TLS_CERT_FILE", cfg.String("TLS_CERT_FILE", "server.crt"), "server.crt", config.SourceDefault},
+	}
+	if err := cfg.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sources := map[string]string{}
+	for _, s := range cfg.Settings() {
+		sources[s.Key] = s.Source
+	}
+	for _, ts := range testCases {
+		if ts.got != ts.expected {
+			t.Errorf("%s: got %v want %v", ts.key, ts.got, ts.expected)
+		}
+		if sources[ts.key] != ts.expectedSource {
+			t.Errorf("%s: got source %s want %s", ts.key, sources[ts.key], ts.expectedSource)
+		}
+	}
+
+	// Without the config file the env file gives the values, and it names
+	// the config file like the environment does.
+	cfg, err = config.LoadEnvFile(writeFile(t, "variables.env", "TIME_ADDITION_MS=2000\nCONFIG_FILE="+path+"\n"), nil)
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if got := cfg.Port("PORT", "9000"); got != "8081" {
+		t.Errorf("PORT: got %v want 8081", got)
+	}
+	cfg, err = config.LoadEnvFile(envPath, nil)
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if got := cfg.Millis("TIME_ADDITION_MS", time.Millisecond); got != 2*time.Second {
+		t.Errorf("TIME_ADDITION_MS: got %v want %v", got, 2*time.Second)
+	}
+	if got := cfg.Settings()[0].Source; got != config.SourceEnvFile {
+		t.Errorf("TIME_ADDITION_MS: got source %s want %s", got, config.SourceEnvFile)
+	}
+}
+
+func TestTOML(t *testing.T) {
+	path := writeFile(t, "calc.toml", `
+port = 9000
+optimization = false
+rate_limit_rps = 2.5
+
+[agent_tls]
+ca_file = "ca.crt"
+`)
+	cfg, err := config.Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if got := cfg.Port("PORT", "8080"); got != "9000" {
+		t.Errorf("PORT: got %v want 9000", got)
+	}
+	if got := cfg.Bool("OPTIMIZATION", true); got {
+		t.Errorf("OPTIMIZATION: got %v want false", got)
+	}
+	if got := cfg.Float("RATE_LIMIT_RPS", 10, 0); got != 2.5 {
+		t.Errorf("RATE_LIMIT_RPS: got %v want 2.5", got)
+	}
+	if got := cfg.String("AGENT_TLS_CA_FILE", ""); got != "ca.crt" {
+		t.Errorf("AGENT_TLS_CA_FILE: got %v want ca.crt", got)
+	}
+	if err := cfg.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name  string
+		args  []string
+		read  func(*config.Config)
+		valid bool
+	}{
+		{"highest port", []string{"-port=65535"}, func(c *config.Config) { c.Port("PORT", "8080") }, true},
+		{"port above range", []string{"-port=65536"}, func(c *config.Config) { c.Port("PORT", "8080") }, false},
+		{"zero port", []string{"-port=0"}, func(c *config.Config) { c.Port("PORT", "8080") }, false},
+		{"optional port", nil, func(c *config.Config) { c.Port("INTERNAL_PORT", "") }, true},
+		{"not a number", []string{"-computing-power=many"}, func(c *config.Config) { c.Int("COMPUTING_POWER", 1, 1) }, false},
+		{"below minimum", []string{"-computing-power=0"}, func(c *config.Config) { c.Int("COMPUTING_POWER", 1, 1) }, false},
+		{"zero duration", []string{"-lease-timeout-ms=0"}, func(c *config.Config) { c.Millis("LEASE_TIMEOUT_MS", time.Second) }, false},
+		{"not a bool", []string{"-optimization=maybe"}, func(c *config.Config) { c.Bool("OPTIMIZATION", true) }, false},
+		{"allowed value", []string{"-log-format=JSON"}, func(c *config.Config) { c.OneOf("LOG_FORMAT", "text", "text", "json") }, true},
+		{"unknown value", []string{"-log-format=xml"}, func(c *config.Config) { c.OneOf("LOG_FORMAT", "text", "text", "json") }, false},
+		{"unknown flag", []string{"-prot=8080"}, func(c *config.Config) { c.Port("PORT", "8080") }, false},
+	}
+	for _, ts := range testCases {
+		cfg, err := config.Load(ts.args)
+		if err != nil {
+			t.Fatalf("%s: failed to load the config: %v", ts.name, err)
+		}
+		ts.read(cfg)
+		err = cfg.Err()
+		if ts.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", ts.name, err)
+		}
+		if !ts.valid && !errors.Is(err, calcerrors.ErrVariableValue) {
+			t.Errorf("%s: got error %v want %v", ts.name, err, calcerrors.ErrVariableValue)
+		}
+	}
+
+	for _, args := range [][]string{{"port"}, {"-port"}, {"-config", "calc.ini"}} {
+		if _, err := config.Load(args); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := config.Load([]string{"-jwt-secret=qwerty"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if got := cfg.Secret("JWT_SECRET", ""); got != "qwerty" {
+		t.Fatalf("JWT_SECRET: got %q want qwerty", got)
+	}
+	for _, s := range cfg.Settings() {
+		if s.Key == "JWT_SECRET" && s.Value != "***" {
+			t.Errorf("the secret is printed: %q", s.Value)
+		}
+	}
+}
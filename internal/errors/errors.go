@@ -11,4 +11,43 @@ var (
 	ErrClosingBracket = errors.New("mismatched closing bracket")
 	ErrVariableValue  = errors.New("invalid environment variable value")
 	ErrDivisionByZero = errors.New("division by zero is prohibited")
+	ErrNonFinite      = errors.New("big precision requires finite arguments")
+	ErrResultInfinite = errors.New("result is not a finite number")
+
+	ErrUnauthorized       = errors.New("authorization required")
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	ErrUserExists         = errors.New("user with this login already exists")
+	ErrPasswordTooLong    = errors.New("password is longer than 72 bytes")
+	ErrForbidden          = errors.New("administrator rights required")
+
+	ErrTaskResolved  = errors.New("task is already resolved")
+	ErrLeaseMismatch = errors.New("task is leased to another agent")
+	ErrTaskCancelled = errors.New("task is cancelled")
+	ErrTaskFailed    = errors.New("task has failed")
+
+	ErrExpressionResolved = errors.New("expression is already resolved")
+	ErrExpressionFailed   = errors.New("expression has failed")
+	ErrUnknownOperation   = errors.New("unknown operation")
+	ErrCostTooLong        = errors.New("operation time must be shorter than the lease")
+
+	ErrRateLimited     = errors.New("too many requests")
+	ErrExpressionQuota = errors.New("quota of unresolved expressions exceeded")
+	ErrTaskQuota       = errors.New("quota of unresolved tasks exceeded")
+
+	ErrBodyTooLarge      = errors.New("request body is too large")
+	ErrExpressionTooLong = errors.New("expression is too long")
+	ErrTooManyTokens     = errors.New("expression has too many tokens")
+	ErrNestingTooDeep    = errors.New("expression brackets are nested too deep")
+	ErrTooManyTasks      = errors.New("expression requires too many tasks")
+
+	ErrUnknownFunction = errors.New("unknown function")
+	ErrFunctionExists  = errors.New("function with this name already exists")
+	ErrInvalidModule   = errors.New("invalid WebAssembly module")
+	ErrNotCompiled     = errors.New("module is not compiled")
+	ErrFunctionQuota   = errors.New("quota of functions exceeded")
+	ErrFunctionTimeout = errors.New("function exceeded the time limit")
+	ErrFunctionFailed  = errors.New("function failed")
+
+	ErrIdempotencyConflict   = errors.New("idempotency key was already used with a different request")
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is still being processed")
 )
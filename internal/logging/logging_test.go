@@ -0,0 +1,101 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
+)
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		level    string
+		expected slog.Level
+	}{
+		{"debug", slog.LevelDebug},
+		{"INFO", slog.LevelInfo},
+		{"warn", slog.LevelWarn},
+		{"error", slog.LevelError},
+		{"", slog.LevelInfo},
+		{"verbose", slog.LevelInfo},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.level, func(t *testing.T) {
+			t.Parallel()
+			if got := logging.ParseLevel(tc.level); got != tc.expected {
+				t.Errorf("expected level %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	logger := logging.New(&buf, "json", "warn")
+	logger.Info("dropped")
+	logger.Warn("kept", "task_id", 7)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 record, got %d: %q", len(lines), buf.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("record is not json: %v", err)
+	}
+	if record["msg"] != "kept" || record["task_id"] != float64(7) {
+		t.Errorf("unexpected record: %v", record)
+	}
+
+	buf.Reset()
+	logging.New(&buf, "text", "").Info("plain", "agent_id", "a1")
+	if !strings.Contains(buf.String(), "msg=plain agent_id=a1") {
+		t.Errorf("unexpected text record: %q", buf.String())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	var seen string
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+
+	testCases := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated", "", false},
+		{"propagated", "abc-123", true},
+		{"too long", strings.Repeat("x", 200), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.incoming != "" {
+				req.Header.Set(logging.RequestIDHeader, tc.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			got := w.Header().Get(logging.RequestIDHeader)
+			if got == "" || got != seen {
+				t.Fatalf("expected the response id %q to match the context id %q", got, seen)
+			}
+			if tc.keep != (got == tc.incoming) {
+				t.Errorf("unexpected request id %q for incoming %q", got, tc.incoming)
+			}
+		})
+	}
+}
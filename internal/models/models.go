@@ -4,8 +4,21 @@ import (
 	"time"
 )
 
+type ReqAuth struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type RespLogin struct {
+	Token string `json:"token"`
+}
+
+// ReqAddExpr submits an expression. Precision is "float", the default, or
+// "big" to have the expression computed only by agents supporting it.
 type ReqAddExpr struct {
-	Expression string `json:"expression"`
+	Expression          string `json:"expression"`
+	DisableOptimization bool   `json:"disable_optimization,omitempty"`
+	Precision           string `json:"precision,omitempty"`
 }
 
 type RespAddExpr struct {
@@ -13,21 +26,135 @@ type RespAddExpr struct {
 }
 
 type RespExpr struct {
-	ID     int     `json:"id"`
-	Status string  `json:"status"`
-	Result float64 `json:"result"`
+	ID               int            `json:"id"`
+	Status           string         `json:"status"`
+	Result           float64        `json:"result"`
+	Body             string         `json:"body"`
+	CreatedAt        time.Time      `json:"created_at"`
+	StartedAt        *time.Time     `json:"started_at,omitempty"`
+	FinishedAt       *time.Time     `json:"finished_at,omitempty"`
+	ComputeTime      time.Duration  `json:"compute_time"`
+	CriticalPathTime time.Duration  `json:"critical_path_time"`
+	Tasks            map[string]int `json:"tasks"`
+	Precision        string         `json:"precision,omitempty"`
+	Error            string         `json:"error,omitempty"`
+}
+
+// RespExprTask describes one task of an expression. Deps lists the tasks
+// whose results the task waits for.
+type RespExprTask struct {
+	ID            int           `json:"id"`
+	Operation     string        `json:"operation"`
+	Arg1          float64       `json:"arg1"`
+	Arg2          float64       `json:"arg2"`
+	Deps          []int         `json:"deps"`
+	Status        string        `json:"status"`
+	Result        float64       `json:"result"`
+	Agent         string        `json:"agent,omitempty"`
+	OperationTime time.Duration `json:"operation_time"`
+	QueuedAt      time.Time     `json:"queued_at"`
+	DispatchedAt  *time.Time    `json:"dispatched_at,omitempty"`
+}
+
+// ReqCosts sets the time in milliseconds agents spend on the listed
+// operations. Zero leaves the time to the agents.
+type ReqCosts struct {
+	Costs map[string]int64 `json:"costs_ms"`
+}
+
+type RespCosts struct {
+	Costs map[string]int64 `json:"costs_ms"`
+}
+
+// ReqAddFunction uploads a user function. Module is a WebAssembly module
+// exporting a function called Name, which takes one f64 and returns one f64.
+type ReqAddFunction struct {
+	Name   string `json:"name"`
+	Module []byte `json:"module"`
+}
+
+type RespFunction struct {
+	Name      string    `json:"name"`
+	Digest    string    `json:"digest"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RespOptimized struct {
+	ID           int    `json:"id"`
+	Body         string `json:"body"`
+	Optimized    string `json:"optimized"`
+	Optimization bool   `json:"optimization"`
 }
 
+// ReqTask is the result of a task. Error is set instead of the result when
+// the agent failed to compute the task, which fails the whole expression.
 type ReqTask struct {
 	ID            int           `json:"id"`
 	Result        float64       `json:"result"`
 	OperationTime time.Duration `json:"operation_time"`
+	Error         string        `json:"error,omitempty"`
+	// BigResult is the result of a big precision task in full precision,
+	// in decimal. The tasks depending on it get it as their arguments.
+	BigResult string `json:"big_result,omitempty"`
 }
 
 type RespTask struct {
 	ID            int           `json:"id"`
+	ExprID        int           `json:"expression_id"`
 	Arg1          float64       `json:"arg1"`
 	Arg2          float64       `json:"arg2"`
 	Operation     string        `json:"operation"`
 	OperationTime time.Duration `json:"operation_time"`
+	Precision     string        `json:"precision,omitempty"`
+	// Function is the digest of the module to call for a task of a user
+	// function. The module is served at /internal/functions/{digest}.
+	Function string `json:"function,omitempty"`
+	// BigArg1 and BigArg2 are the arguments of a big precision task
+	// computed by other tasks, in full precision.
+	BigArg1 string `json:"big_arg1,omitempty"`
+	BigArg2 string `json:"big_arg2,omitempty"`
+	// Traceparent is the trace context of the task in a batch, where it
+	// cannot be sent in a header.
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// ReqTasks carries several results of an agent in one request.
+type ReqTasks struct {
+	Results []ReqTask `json:"results"`
+}
+
+type RespTasks struct {
+	Tasks []RespTask `json:"tasks"`
+}
+
+// RespResult is the outcome of one result of a batch. Status is the code the
+// orchestrator answers with when the result is sent alone.
+type RespResult struct {
+	ID     int    `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type RespResults struct {
+	Results []RespResult `json:"results"`
+}
+
+// RespAgent describes an agent by its requests to the internal API. An agent
+// is connected while it keeps asking for tasks.
+type RespAgent struct {
+	ID             string    `json:"id"`
+	Connected      bool      `json:"connected"`
+	LastSeen       time.Time `json:"last_seen"`
+	TasksInFlight  int       `json:"tasks_in_flight"`
+	TasksCompleted int       `json:"tasks_completed"`
+	Operations     []string  `json:"operations,omitempty"`
+	Precisions     []string  `json:"precisions,omitempty"`
+	Capacity       int       `json:"capacity,omitempty"`
+	Functions      bool      `json:"functions,omitempty"`
+}
+
+type RespStatus struct {
+	QueueDepth int         `json:"queue_depth"`
+	InFlight   int         `json:"in_flight"`
+	Agents     []RespAgent `json:"agents"`
 }
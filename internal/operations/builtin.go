@@ -0,0 +1,47 @@
+package operations
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+)
+
+// The four arithmetic operations every installation has. A new operation is
+// added with one more MustRegister call here.
+func init() {
+	MustRegister(Operation{
+		Symbol: "+", Arity: 2, Precedence: 1, Associativity: Left, Associative: true,
+		Eval:    func(a, b float64) (float64, error) { return a + b, nil },
+		EvalBig: func(a, b *big.Float) (*big.Float, error) { return new(big.Float).SetPrec(a.Prec()).Add(a, b), nil },
+		Cost:    time.Millisecond, Setting: "TIME_ADDITION_MS",
+	})
+	MustRegister(Operation{
+		Symbol: "-", Arity: 2, Precedence: 1, Associativity: Left,
+		Eval:    func(a, b float64) (float64, error) { return a - b, nil },
+		EvalBig: func(a, b *big.Float) (*big.Float, error) { return new(big.Float).SetPrec(a.Prec()).Sub(a, b), nil },
+		Cost:    time.Millisecond, Setting: "TIME_SUBTRACTION_MS",
+	})
+	MustRegister(Operation{
+		Symbol: "*", Arity: 2, Precedence: 2, Associativity: Left, Associative: true,
+		Eval:    func(a, b float64) (float64, error) { return a * b, nil },
+		EvalBig: func(a, b *big.Float) (*big.Float, error) { return new(big.Float).SetPrec(a.Prec()).Mul(a, b), nil },
+		Cost:    time.Millisecond, Setting: "TIME_MULTIPLICATIONS_MS",
+	})
+	MustRegister(Operation{
+		Symbol: "/", Arity: 2, Precedence: 2, Associativity: Left,
+		Eval: func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errors.ErrDivisionByZero
+			}
+			return a / b, nil
+		},
+		EvalBig: func(a, b *big.Float) (*big.Float, error) {
+			if b.Sign() == 0 {
+				return nil, errors.ErrDivisionByZero
+			}
+			return new(big.Float).SetPrec(a.Prec()).Quo(a, b), nil
+		},
+		Cost: time.Millisecond, Setting: "TIME_DIVISIONS_MS",
+	})
+}
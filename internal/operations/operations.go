@@ -0,0 +1,188 @@
+// Package operations is the registry of the operators expressions may use.
+// The orchestrator parses, checks and simplifies expressions with it, and
+// agents compute tasks with it, so an operator registered here is known to
+// both.
+package operations
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+)
+
+// Associativity says how a chain of operators of the same precedence is
+// grouped.
+type Associativity int
+
+const (
+	// Left groups a-b-c as (a-b)-c.
+	Left Associativity = iota
+	// Right groups a^b^c as a^(b^c).
+	Right
+)
+
+// Operation describes an operator. Only binary operators written between
+// their operands are supported, since a task carries two arguments.
+type Operation struct {
+	// Symbol is the single character of the operator in expressions.
+	Symbol string
+	// Arity is the number of operands, it must be 2.
+	Arity int
+	// Precedence orders the operators: higher ones bind tighter.
+	Precedence    int
+	Associativity Associativity
+	// Associative allows the orchestrator to regroup chains of the
+	// operator, so that their tasks can be computed in parallel.
+	Associative bool
+	// Eval computes the operation in float precision. An error makes the
+	// expression invalid when it is found while the expression is submitted.
+	Eval func(a, b float64) (float64, error)
+	// EvalBig computes the operation in big precision. Without it Eval is
+	// used.
+	EvalBig func(a, b *big.Float) (*big.Float, error)
+	// Cost is the time agents spend on the operation unless the setting
+	// named by Setting says otherwise. The orchestrator reads the setting
+	// with the ORCHESTRATOR_ prefix first.
+	Cost    time.Duration
+	Setting string
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Operation)
+	order    []string
+)
+
+// Register adds the operation to the registry. It fails when the symbol is
+// taken or the operation cannot be parsed or computed.
+func Register(op Operation) error {
+	switch {
+	case len(op.Symbol) != 1 || !validSymbol(op.Symbol[0]):
+		return fmt.Errorf("operation %q: the symbol must be one punctuation character other than a dot, a bracket, an underscore or a comma", op.Symbol)
+	case op.Arity != 2:
+		return fmt.Errorf("operation %q: only binary operations are supported", op.Symbol)
+	case op.Precedence < 1:
+		return fmt.Errorf("operation %q: the precedence must be positive", op.Symbol)
+	case op.Eval == nil:
+		return fmt.Errorf("operation %q: Eval is required", op.Symbol)
+	case op.Cost < 0:
+		return fmt.Errorf("operation %q: the cost must not be negative", op.Symbol)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[op.Symbol]; ok {
+		return fmt.Errorf("operation %q is already registered", op.Symbol)
+	}
+	registry[op.Symbol] = op
+	order = append(order, op.Symbol)
+	return nil
+}
+
+// MustRegister is Register that panics on error, for operations registered
+// from init functions.
+func MustRegister(op Operation) {
+	if err := Register(op); err != nil {
+		panic(err)
+	}
+}
+
+// validSymbol reports whether the tokenizer reads c as an operator. Digits
+// and dots make up numbers, brackets group, and letters and underscores start
+// function names, so only the other ASCII punctuation characters are left.
+// Commas separate the operations agents advertise, so they are left out too.
+func validSymbol(c byte) bool {
+	switch {
+	case c == '.', c == '(', c == ')', c == '_', c == ',':
+		return false
+	}
+	return c < utf8.RuneSelf && (unicode.IsPunct(rune(c)) || unicode.IsSymbol(rune(c)))
+}
+
+// Lookup returns the operation with the symbol.
+func Lookup(symbol string) (Operation, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	op, ok := registry[symbol]
+	return op, ok
+}
+
+// All returns the registered operations in the order they were registered.
+func All() []Operation {
+	mu.RLock()
+	defer mu.RUnlock()
+	ops := make([]Operation, len(order))
+	for i, symbol := range order {
+		ops[i] = registry[symbol]
+	}
+	return ops
+}
+
+// Symbols returns the symbols of the registered operations in the order they
+// were registered.
+func Symbols() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]string(nil), order...)
+}
+
+// Evaluate computes the operation with the symbol in float precision.
+func Evaluate(symbol string, a, b float64) (float64, error) {
+	op, ok := Lookup(symbol)
+	if !ok {
+		return 0, fmt.Errorf("unknown operation %q", symbol)
+	}
+	return op.Eval(a, b)
+}
+
+// EvaluateBig computes the operation with the symbol in big precision, with
+// the mantissa of x. Operations without EvalBig are computed in float64.
+// Big floats have no NaN, so operations without a result, like Inf-Inf,
+// fail with errors.ErrNonFinite, as do infinite arguments.
+func EvaluateBig(symbol string, x, y *big.Float) (z *big.Float, err error) {
+	op, ok := Lookup(symbol)
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %q", symbol)
+	}
+	if x.IsInf() || y.IsInf() {
+		return nil, errors.ErrNonFinite
+	}
+	if op.EvalBig == nil {
+		a, _ := x.Float64()
+		b, _ := y.Float64()
+		result, err := op.Eval(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return BigFloat(result, x.Prec())
+	}
+	// math/big panics when an operation has no result.
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(big.ErrNaN); !ok {
+				panic(r)
+			}
+			z, err = nil, errors.ErrNonFinite
+		}
+	}()
+	return op.EvalBig(x, y)
+}
+
+// BigFloat converts the value to a big float with a mantissa of prec bits.
+// Infinite and NaN values fail with errors.ErrNonFinite.
+func BigFloat(v float64, prec uint) (*big.Float, error) {
+	if !finite(v) {
+		return nil, errors.ErrNonFinite
+	}
+	return new(big.Float).SetPrec(prec).SetFloat64(v), nil
+}
+
+func finite(v float64) bool {
+	return !math.IsInf(v, 0) && !math.IsNaN(v)
+}
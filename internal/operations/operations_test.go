@@ -0,0 +1,127 @@
+package operations_test
+
+import (
+	stderrors "errors"
+	"math"
+	"math/big"
+	"testing"
+
+	calcerrors "github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/operations"
+)
+
+func TestRegister(t *testing.T) {
+	t.Parallel()
+
+	eval := func(a, b float64) (float64, error) { return a, nil }
+	testCases := []struct {
+		name  string
+		op    operations.Operation
+		valid bool
+	}{
+		{"taken symbol", operations.Operation{Symbol: "+", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"long symbol", operations.Operation{Symbol: "**", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"digit", operations.Operation{Symbol: "7", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"bracket", operations.Operation{Symbol: "(", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"letter", operations.Operation{Symbol: "x", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"capital letter", operations.Operation{Symbol: "X", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"underscore", operations.Operation{Symbol: "_", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"comma", operations.Operation{Symbol: ",", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"space", operations.Operation{Symbol: " ", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"non-ASCII", operations.Operation{Symbol: "\xd7", Arity: 2, Precedence: 1, Eval: eval}, false},
+		{"unary", operations.Operation{Symbol: "!", Arity: 1, Precedence: 1, Eval: eval}, false},
+		{"no precedence", operations.Operation{Symbol: "!", Arity: 2, Eval: eval}, false},
+		{"no evaluation", operations.Operation{Symbol: "!", Arity: 2, Precedence: 1}, false},
+		{"negative cost", operations.Operation{Symbol: "!", Arity: 2, Precedence: 1, Eval: eval, Cost: -1}, false},
+		{"valid", operations.Operation{Symbol: "!", Arity: 2, Precedence: 3, Associativity: operations.Right, Eval: eval}, true},
+	}
+	for _, ts := range testCases {
+		if err := operations.Register(ts.op); (err == nil) != ts.valid {
+			t.Errorf("%s: unexpected error: %v", ts.name, err)
+		}
+	}
+	if op, ok := operations.Lookup("!"); !ok || op.Associativity != operations.Right {
+		t.Errorf("the registered operation was not found: %+v", op)
+	}
+	symbols := operations.Symbols()
+	if len(symbols) < 5 || symbols[0] != "+" || symbols[3] != "/" {
+		t.Errorf("invalid symbols: %v", symbols)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		symbol      string
+		arg1        float64
+		arg2        float64
+		expected    float64
+		expectedErr error
+	}{
+		{"addition", "+", 2, 3, 5, nil},
+		{"subtraction", "-", 2, 3, -1, nil},
+		{"multiplication", "*", 2, 3, 6, nil},
+		{"division", "/", 3, 2, 1.5, nil},
+		{"division by zero", "/", 3, 0, 0, calcerrors.ErrDivisionByZero},
+	}
+	for _, ts := range testCases {
+		result, err := operations.Evaluate(ts.symbol, ts.arg1, ts.arg2)
+		if !stderrors.Is(err, ts.expectedErr) || result != ts.expected {
+			t.Errorf("%s: got %v %v want %v %v", ts.name, result, err, ts.expected, ts.expectedErr)
+		}
+		result, err = evaluateBig(ts.symbol, ts.arg1, ts.arg2)
+		if !stderrors.Is(err, ts.expectedErr) || result != ts.expected {
+			t.Errorf("%s in big precision: got %v %v want %v %v", ts.name, result, err, ts.expected, ts.expectedErr)
+		}
+	}
+	if _, err := operations.Evaluate("%", 1, 2); err == nil {
+		t.Error("an unknown operation was computed")
+	}
+}
+
+func evaluateBig(symbol string, a, b float64) (float64, error) {
+	x, err := operations.BigFloat(a, 256)
+	if err != nil {
+		return 0, err
+	}
+	y, err := operations.BigFloat(b, 256)
+	if err != nil {
+		return 0, err
+	}
+	z, err := operations.EvaluateBig(symbol, x, y)
+	if err != nil {
+		return 0, err
+	}
+	result, _ := z.Float64()
+	return result, nil
+}
+
+func TestEvaluateBigNonFinite(t *testing.T) {
+	t.Parallel()
+
+	inf, nan := math.Inf(1), math.NaN()
+	testCases := []struct {
+		name   string
+		symbol string
+		arg1   float64
+		arg2   float64
+	}{
+		{"NaN", "+", nan, 1},
+		{"infinity minus infinity", "-", inf, inf},
+		{"zero times infinity", "*", 0, inf},
+		{"infinity divided by infinity", "/", inf, inf},
+		{"infinity plus a number", "+", 1, -inf},
+	}
+	for _, ts := range testCases {
+		if _, err := evaluateBig(ts.symbol, ts.arg1, ts.arg2); !stderrors.Is(err, calcerrors.ErrNonFinite) {
+			t.Errorf("%s: got %v want %v", ts.name, err, calcerrors.ErrNonFinite)
+		}
+	}
+	// Infinite big floats are rejected as well.
+	x := new(big.Float).SetInf(false)
+	if _, err := operations.EvaluateBig("-", x, x); !stderrors.Is(err, calcerrors.ErrNonFinite) {
+		t.Errorf("infinite big floats: got %v want %v", err, calcerrors.ErrNonFinite)
+	}
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	l := New(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("alice"); !ok {
+			t.Fatalf("request %d within the burst was rejected", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("alice")
+	if ok {
+		t.Fatalf("request over the burst was allowed")
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Fatalf("invalid retry after: got %v want %v", retryAfter, 500*time.Millisecond)
+	}
+	if ok, _ := l.Allow("bob"); !ok {
+		t.Fatalf("request of another key was rejected")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("alice"); !ok {
+		t.Fatalf("request after refill was rejected")
+	}
+	if ok, _ := l.Allow("alice"); ok {
+		t.Fatalf("bucket was refilled too fast")
+	}
+
+	now = now.Add(time.Hour)
+	l.Allow("carol")
+	if _, ok := l.buckets["alice"]; ok {
+		t.Fatalf("full bucket was not forgotten")
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	t.Parallel()
+
+	l := New(0, 1)
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("alice"); !ok {
+			t.Fatalf("disabled limiter rejected a request")
+		}
+	}
+}
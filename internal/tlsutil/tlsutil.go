@@ -0,0 +1,163 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Reloader holds a certificate, its key and an optional CA bundle loaded from
+// files, and loads them again on Reload so that new certificates are picked up
+// without a restart.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// NewReloader loads the files. The certificate and the CA bundle are both
+// optional, an empty file name skips them.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) Reload() error {
+	var cert *tls.Certificate
+	if r.certFile != "" || r.keyFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate: %w", err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("load CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("load CA: no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.pool = cert, pool
+	return nil
+}
+
+// WatchSIGHUP reloads the files every time the process receives SIGHUP.
+// A failed reload keeps the previous certificates.
+func (r *Reloader) WatchSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := r.Reload(); err != nil {
+				slog.Error("certificates were not reloaded", "error", err)
+				continue
+			}
+			slog.Info("certificates were successfully reloaded")
+		}
+	}()
+}
+
+func (r *Reloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+func (r *Reloader) certPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// ServerConfig returns a server configuration presenting the current
+// certificate. When the reloader has a CA bundle, client certificates are
+// verified against it according to clientAuth. HTTP/2 and HTTP/1.1 are
+// offered, the protocols http.Server serves over TLS.
+func (r *Reloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	// The configuration returned for a connection replaces the base one, so
+	// it must offer the protocols itself: http.Server adds them only to its
+	// own copy of the base configuration.
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert := r.certificate()
+		if cert == nil {
+			return nil, fmt.Errorf("no server certificate configured")
+		}
+		cfg := &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*cert},
+			NextProtos:   base.NextProtos,
+		}
+		if pool := r.certPool(); pool != nil {
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = clientAuth
+		}
+		return cfg, nil
+	}
+	return base
+}
+
+// ClientConfig returns a client configuration trusting the CA bundle of the
+// reloader, or the system roots when it has none, and presenting the current
+// certificate when the server asks for one. The certificate of the server
+// must be valid for serverName and is verified against the CA bundle loaded
+// last, so a reload takes effect for new connections.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := r.certificate(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+	if r.caFile == "" {
+		return cfg
+	}
+	// RootCAs would be fixed for the lifetime of the config, so the
+	// standard verification is replaced with one using the current pool.
+	// The name of the server is not taken from the connection state, which
+	// has no name for servers reached by an IP address.
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("the server presented no certificate")
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         r.certPool(),
+			Intermediates: intermediates,
+		})
+		return err
+	}
+	return cfg
+}
@@ -0,0 +1,208 @@
+package tlsutil_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tlsutil"
+)
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue creates a certificate signed by parent, or a self-signed CA when
+// parent is nil, and writes it with its key to dir.
+func issue(t *testing.T, dir, name string, serial int64, parent *keyPair) *keyPair {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	write := func(file, typ string, der []byte) {
+		if err := os.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", file, err)
+		}
+	}
+	write(name+".crt", "CERTIFICATE", der)
+	write(name+".key", "EC PRIVATE KEY", keyDER)
+	return &keyPair{cert: cert, key: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := func(file string) string { return filepath.Join(dir, file) }
+	ca := issue(t, dir, "ca", 1, nil)
+	issue(t, dir, "server", 2, ca)
+	issue(t, dir, "agent", 3, ca)
+
+	serverCerts, err := tlsutil.NewReloader(path("server.crt"), path("server.key"), path("ca.crt"))
+	if err != nil {
+		t.Fatalf("failed to load server certificates: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+	}))
+	srv.TLS = serverCerts.ServerConfig(tls.RequireAndVerifyClientCert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(certs *tlsutil.Reloader) (string, *tls.ConnectionState, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: certs.ClientConfig("127.0.0.1")}}
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			return "", nil, err
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body), res.TLS, nil
+	}
+
+	agentCerts, err := tlsutil.NewReloader(path("agent.crt"), path("agent.key"), path("ca.crt"))
+	if err != nil {
+		t.Fatalf("failed to load agent certificates: %v", err)
+	}
+	name, state, err := get(agentCerts)
+	if err != nil {
+		t.Fatalf("request with a client certificate failed: %v", err)
+	}
+	if name != "agent" {
+		t.Fatalf("invalid client name: got %v want %v", name, "agent")
+	}
+	if serial := state.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Fatalf("invalid server certificate: got serial %v want 2", serial)
+	}
+
+	anonymous, err := tlsutil.NewReloader("", "", path("ca.crt"))
+	if err != nil {
+		t.Fatalf("failed to load CA: %v", err)
+	}
+	if _, _, err := get(anonymous); err == nil {
+		t.Fatalf("request without a client certificate was accepted")
+	}
+
+	issue(t, dir, "server", 4, ca)
+	if err := serverCerts.Reload(); err != nil {
+		t.Fatalf("failed to reload certificates: %v", err)
+	}
+	agentCerts, _ = tlsutil.NewReloader(path("agent.crt"), path("agent.key"), path("ca.crt"))
+	if _, state, err = get(agentCerts); err != nil {
+		t.Fatalf("request after reload failed: %v", err)
+	}
+	if serial := state.PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+		t.Fatalf("certificate was not reloaded: got serial %v want 4", serial)
+	}
+}
+
+func TestClientReload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := func(file string) string { return filepath.Join(dir, file) }
+	ca := issue(t, dir, "ca", 1, nil)
+	issue(t, dir, "server", 2, ca)
+	issue(t, dir, "trusted", 3, nil)
+
+	serverCerts, err := tlsutil.NewReloader(path("server.crt"), path("server.key"), "")
+	if err != nil {
+		t.Fatalf("failed to load server certificates: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	srv.EnableHTTP2 = true
+	srv.TLS = serverCerts.ServerConfig(tls.NoClientCert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	clientCerts, err := tlsutil.NewReloader("", "", path("trusted.crt"))
+	if err != nil {
+		t.Fatalf("failed to load CA: %v", err)
+	}
+	get := func(serverName string) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCerts.ClientConfig(serverName), ForceAttemptHTTP2: true}}
+		defer client.CloseIdleConnections()
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body), nil
+	}
+
+	if _, err := get("127.0.0.1"); err == nil {
+		t.Fatalf("a server signed by an untrusted CA was accepted")
+	}
+	// The client trusts the reloaded CA without a new configuration.
+	pem, _ := os.ReadFile(path("ca.crt"))
+	os.WriteFile(path("trusted.crt"), pem, 0o600)
+	if err := clientCerts.Reload(); err != nil {
+		t.Fatalf("failed to reload the CA: %v", err)
+	}
+	proto, err := get("127.0.0.1")
+	if err != nil {
+		t.Fatalf("request after the reload failed: %v", err)
+	}
+	if proto != "HTTP/2.0" {
+		t.Errorf("invalid protocol: got %v want HTTP/2.0", proto)
+	}
+	if _, err := get("example.com"); err == nil {
+		t.Errorf("a certificate for another name was accepted")
+	}
+}
+
+func TestReloaderInvalidFiles(t *testing.T) {
+	t.Parallel()
+
+	if _, err := tlsutil.NewReloader("missing.crt", "missing.key", ""); err == nil {
+		t.Fatalf("missing certificate was accepted")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ca.crt"), []byte("not a certificate"), 0o600)
+	if _, err := tlsutil.NewReloader("", "", filepath.Join(dir, "ca.crt")); err == nil {
+		t.Fatalf("invalid CA was accepted")
+	}
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name identifies the tracer of the calculator.
+const Name = "github.com/kingofhandsomes/distributed_calculator_go"
+
+// propagator carries trace context in the W3C traceparent header, so spans of
+// the orchestrator and the agents join the same trace.
+var propagator = propagation.TraceContext{}
+
+// Setup installs the global tracer provider with the given exporter:
+// "stdout" writes spans as JSON to the standard output, "file" appends them
+// to path, and an empty value leaves tracing disabled. The returned function
+// flushes the remaining spans.
+func Setup(service, exporter, path string) (func(context.Context) error, error) {
+	var w io.Writer
+	var closer io.Closer
+	switch exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		w = os.Stdout
+	case "file":
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = f, f
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, err
+	}
+	tp := NewProvider(exp, service)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+// NewProvider returns a tracer provider batching spans of the service to the
+// exporter.
+func NewProvider(exp sdktrace.SpanExporter, service string) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+}
+
+// Tracer returns the tracer of the global provider. It follows the provider
+// installed by Setup even when it is obtained earlier.
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// Inject writes the trace context of ctx to the headers.
+func Inject(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// Extract returns ctx with the remote trace context found in the headers.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// Middleware makes the trace context sent by the client the parent of the
+// spans started while handling the request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(Extract(r.Context(), r.Header)))
+	})
+}
@@ -0,0 +1,66 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := tracing.Setup("test", "file", path)
+	if err != nil {
+		t.Fatalf("failed to set up tracing: %v", err)
+	}
+	_, span := tracing.Tracer().Start(context.Background(), "TestSpan")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down tracing: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read traces: %v", err)
+	}
+	for _, want := range []string{`"Name":"TestSpan"`, `"Value":"test"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s is missing from the exported spans: %s", want, data)
+		}
+	}
+
+	if _, err := tracing.Setup("test", "collector", path); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	t.Parallel()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+
+	h := http.Header{}
+	tracing.Inject(trace.ContextWithSpanContext(context.Background(), sc), h)
+	if got, want := h.Get("traceparent"), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; got != want {
+		t.Fatalf("invalid traceparent: got %q want %q", got, want)
+	}
+
+	var seen trace.SpanContext
+	handler := tracing.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = trace.SpanContextFromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header = h
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if seen.TraceID() != traceID || seen.SpanID() != spanID || !seen.IsRemote() {
+		t.Errorf("the trace context was not extracted: %v", seen)
+	}
+}
@@ -1,123 +1,458 @@
 package agent
 
 import (
-	"bytes"
-	"encoding/json"
-	"log"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/big"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/joho/godotenv"
-	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/client"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/operations"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tlsutil"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tracing"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/wasm"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Agent struct {
-	Port                string
-	TimeAddition        time.Duration
-	TimeSubtraction     time.Duration
-	TimeMultiplications time.Duration
-	TimeDivisions       time.Duration
-	ComputingPower      int
-}
-
-func NewAgent() *Agent {
-	godotenv.Load("variables.env")
-	port := os.Getenv("PORT")
-	intPort, err := strconv.Atoi(port)
-	if port == "" || err != nil {
-		port = "8080"
-	}
-	if intPort < 0 || intPort > 9999 {
-		port = "8080"
-	}
-	ta, err := strconv.Atoi(os.Getenv("TIME_ADDITION_MS"))
-	if err != nil || ta < 1 {
-		ta = 1
-	}
-	ts, err := strconv.Atoi(os.Getenv("TIME_SUBTRACTION_MS"))
-	if err != nil || ts < 1 {
-		ts = 1
-	}
-	tm, err := strconv.Atoi(os.Getenv("TIME_MULTIPLICATIONS_MS"))
-	if err != nil || tm < 1 {
-		tm = 1
-	}
-	td, err := strconv.Atoi(os.Getenv("TIME_DIVISIONS_MS"))
-	if err != nil || td < 1 {
-		td = 1
-	}
-	cp, err := strconv.Atoi(os.Getenv("COMPUTING_POWER"))
-	if err != nil || cp < 1 {
-		cp = 1
-	}
-	return &Agent{
-		Port:                port,
-		TimeAddition:        time.Duration(ta) * time.Millisecond,
-		TimeSubtraction:     time.Duration(ts) * time.Millisecond,
-		TimeMultiplications: time.Duration(tm) * time.Millisecond,
-		TimeDivisions:       time.Duration(td) * time.Millisecond,
-		ComputingPower:      cp,
-	}
-}
-
-func (a *Agent) Run() {
-	wg := sync.WaitGroup{}
-	for {
-		for i := 0; i < a.ComputingPower; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				a.TaskProcessing(i + 1)
-			}()
+	Host   string
+	Port   string
+	Scheme string
+	Client *http.Client
+	ID     string
+	Token  string
+	// OperationTimes is the time the agent spends on each operation when
+	// the orchestrator does not set it in the task.
+	OperationTimes  map[string]time.Duration
+	ComputingPower  int
+	Prefetch        int
+	MetricsPort     string
+	Metrics         *prometheus.Registry
+	certs           *tlsutil.Reloader
+	m               *agentMetrics
+	logger          *slog.Logger
+	Tracer          trace.Tracer
+	ShutdownTimeout time.Duration
+	// ConfigReloadInterval is how often the config file is checked for
+	// changes of the operation times and the number of workers.
+	ConfigReloadInterval time.Duration
+	// Operations, Precisions, Capacity and Functions are advertised to the
+	// orchestrator, which routes only compatible tasks to the agent. Empty
+	// values advertise nothing.
+	Operations []string
+	Precisions []string
+	Capacity   int
+	Functions  bool
+	// MaxIdleInterval is the longest pause of a worker between attempts to
+	// fetch a task when there are none.
+	MaxIdleInterval time.Duration
+	config          *config.Config
+	// functions runs user functions within the memory and time limits.
+	functions *wasm.Runtime
+	// mu guards the settings that can be changed while the agent runs.
+	mu sync.RWMutex
+	// resized tells Run that ComputingPower was changed.
+	resized chan struct{}
+	pool    pool
+	ready   atomic.Bool
+}
+
+// NewAgent builds an agent from the settings of cfg. Invalid settings are
+// reported together in the returned error.
+func NewAgent(cfg *config.Config) (*Agent, error) {
+	port := cfg.Port("PORT", "8080")
+	if internalPort := cfg.Port("INTERNAL_PORT", ""); internalPort != "" {
+		port = internalPort
+	}
+	hostname, _ := os.Hostname()
+	id := cfg.String("AGENT_ID", fmt.Sprintf("%s-%d", hostname, os.Getpid()))
+	// Every worker keeps a connection to the orchestrator open, so the
+	// shared transport keeps enough idle connections for all of them.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 64
+	scheme, client := "http", &http.Client{Transport: transport}
+	host := cfg.String("ORCHESTRATOR_HOST", "localhost")
+	certFile, keyFile := cfg.String("AGENT_TLS_CERT_FILE", ""), cfg.String("AGENT_TLS_KEY_FILE", "")
+	caFile := cfg.String("AGENT_TLS_CA_FILE", "")
+	var certs *tlsutil.Reloader
+	if cfg.String("TLS_CERT_FILE", "") != "" || caFile != "" {
+		scheme = "https"
+		var err error
+		certs, err = tlsutil.NewReloader(certFile, keyFile, caFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = certs.ClientConfig(host)
+	}
+	// An agent computes every registered operation unless told otherwise.
+	symbols := operations.Symbols()
+	ops := parseCapabilities(cfg, "AGENT_OPERATIONS", symbols...)
+	if len(ops) == 0 {
+		ops = symbols
+	}
+	precisions := parseCapabilities(cfg, "AGENT_PRECISION", "float", "big")
+	s := readSettings(cfg)
+	a := &Agent{
+		Host:                 host,
+		Port:                 port,
+		Scheme:               scheme,
+		Client:               client,
+		certs:                certs,
+		ID:                   id,
+		Token:                cfg.Secret("AGENT_TOKEN", ""),
+		OperationTimes:       s.operationTimes,
+		ComputingPower:       s.computingPower,
+		Prefetch:             cfg.Int("TASK_PREFETCH", 1, 1),
+		Operations:           ops,
+		Precisions:           precisions,
+		Capacity:             cfg.Int("AGENT_CAPACITY", 0, 0),
+		Functions:            cfg.Bool("AGENT_FUNCTIONS", true),
+		MetricsPort:          cfg.Port("AGENT_METRICS_PORT", ""),
+		logger:               slog.Default().With("agent_id", id),
+		Tracer:               tracing.Tracer(),
+		ShutdownTimeout:      cfg.Millis("SHUTDOWN_TIMEOUT_MS", 10*time.Second),
+		ConfigReloadInterval: cfg.Millis("CONFIG_RELOAD_INTERVAL_MS", 2*time.Second),
+		MaxIdleInterval:      cfg.Millis("MAX_IDLE_INTERVAL_MS", 500*time.Millisecond),
+		config:               cfg,
+		resized:              make(chan struct{}, 1),
+		functions: wasm.NewRuntime(context.Background(), wasm.Limits{
+			MemoryPages: uint32(cfg.Int("FUNCTION_MEMORY_PAGES", 16, 1)),
+			Timeout:     cfg.Millis("FUNCTION_TIMEOUT_MS", time.Second),
+			Modules:     cfg.Int("FUNCTION_CACHE_MODULES", 16, 1),
+		}),
+	}
+	if err := cfg.Err(); err != nil {
+		return nil, err
+	}
+	a.registerMetrics()
+	return a, nil
+}
+
+// Run keeps a pool of ComputingPower workers, each fetching and computing
+// tasks on its own, until the context is cancelled. Then it stops taking new
+// tasks and waits up to ShutdownTimeout for the tasks in progress; the ones
+// that are still not computed are released back to the orchestrator.
+func (a *Agent) Run(ctx context.Context) error {
+	if a.certs != nil && a.Scheme == "https" {
+		a.certs.WatchSIGHUP()
+	}
+	var srv *http.Server
+	if a.MetricsPort != "" {
+		srv = a.serveMetrics()
+	}
+	if a.config != nil && a.config.Path() != "" {
+		go a.watchConfig(ctx)
+	}
+
+	work, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	a.resize(ctx, work, a.computingPower())
+	a.ready.Store(true)
+	for ctx.Err() == nil {
+		select {
+		case <-a.resized:
+			a.resize(ctx, work, a.computingPower())
+		case <-ctx.Done():
 		}
-		wg.Wait()
 	}
+
+	a.ready.Store(false)
+	a.logger.Info("the agent is stopping, waiting for the tasks in progress")
+	done := make(chan struct{})
+	go func() {
+		a.pool.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(a.ShutdownTimeout):
+		cancel()
+		<-done
+	}
+	if srv != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}
+	a.functions.Close(context.Background())
+	a.logger.Info("the agent stopped")
+	return nil
+}
+
+func (a *Agent) computingPower() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.ComputingPower
+}
+
+// api returns the client of the internal API of the orchestrator carrying the
+// identity and credentials of the agent.
+func (a *Agent) api() *client.AgentClient {
+	api := client.NewAgentClient(a.Scheme+"://"+a.Host+":"+a.Port, a.ID, a.Token)
+	api.HTTP = a.Client
+	api.Operations, api.Precisions, api.Capacity, api.Functions = a.Operations, a.Precisions, a.Capacity, a.Functions
+	a.mu.RLock()
+	api.OperationTimes = a.OperationTimes
+	a.mu.RUnlock()
+	return api
+}
+
+// TaskProcessing takes one task, computes it and sends the result. When ctx is
+// cancelled during the computation the task is released instead. Requests
+// made for the same task share the request id, so the orchestrator logs them
+// together. It returns client.ErrNoTask when there was nothing to compute and
+// an error when the task could not be fetched, computed or delivered. With
+// Prefetch above one a whole batch of tasks is taken and sent back at once.
+func (a *Agent) TaskProcessing(ctx context.Context, n int) error {
+	_, err := a.process(ctx, n, nil)
+	return err
 }
 
-func (a *Agent) TaskProcessing(n int) {
-	resp, err := http.Get("http://localhost:" + a.Port + "/internal/task")
+// process returns the number of results the orchestrator accepted.
+func (a *Agent) process(ctx context.Context, n int, w *worker) (int, error) {
+	requestID := logging.NewRequestID()
+	logger := a.logger.With("worker", n, "request_id", requestID)
+	ctx = logging.WithRequestID(ctx, requestID)
+	api := a.api()
+	if a.Prefetch > 1 {
+		return a.processBatch(ctx, api, n, w, logger)
+	}
+	task, err := api.Fetch(ctx)
+	if err == client.ErrNoTask {
+		return 0, err
+	}
+	if err != nil {
+		logger.Debug("the task was not fetched", "error", err)
+		a.m.fetchErrors.Inc()
+		return 0, err
+	}
+	w.setBusy(true)
+	defer w.setBusy(false)
+	logger = logger.With("task_id", task.ID, "expression_id", task.ExpressionID)
+	result, err := a.compute(ctx, api, n, task, logger)
 	if err != nil {
-		return
+		// A failed release is logged by release, the compute error is the
+		// one that stopped the task.
+		a.release(api, task, logger)
+		return 0, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return
+	if err := a.submit(ctx, api, task, result, logger); err != nil {
+		return 0, err
 	}
-	var res struct {
-		Task *models.RespTask `json:"task"`
+	return 1, nil
+}
+
+// submit sends the result of the task. The result is sent even when the agent
+// is stopping, since the work is already done.
+func (a *Agent) submit(ctx context.Context, api *client.AgentClient, task *client.Task, result client.TaskResult, logger *slog.Logger) error {
+	traceCtx, span := a.Tracer.Start(task.TraceContext(context.WithoutCancel(ctx)), "SubmitResult", a.taskAttributes(task), trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	if err := api.Submit(traceCtx, result); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		a.m.resultErrors.Inc()
+		logger.Warn("the result was not accepted", "error", err)
+		return err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return
+	return nil
+}
+
+// compute computes the task. A task that cannot be computed, such as a user
+// function running out of time, gives a result with the error. It fails when
+// ctx is cancelled before the result is ready or the module of the function
+// cannot be fetched, the task must be released then.
+func (a *Agent) compute(ctx context.Context, api *client.AgentClient, n int, task *client.Task, logger *slog.Logger) (client.TaskResult, error) {
+	logger.Info("started work with the task", "operation", task.Operation)
+	// The orchestrator sends the context of the task span with the task, so
+	// the work of the agent continues the trace of the expression.
+	_, span := a.Tracer.Start(task.TraceContext(context.WithoutCancel(ctx)), "TaskCalculation", a.taskAttributes(task))
+	defer span.End()
+	var module []byte
+	if task.Function != "" && !a.functions.Compiled(task.Function) {
+		var err error
+		if module, err = api.Module(ctx, task.Function); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			logger.Warn("the module of the function was not fetched", "digest", task.Function, "error", err)
+			return client.TaskResult{}, err
+		}
 	}
-	log.Printf("agent %d started work with task %d\n", n, res.Task.ID)
-	task := res.Task
-	result, duration := a.TaskCalculation(task.Arg1, task.Arg2, task.Operation)
-	body, _ := json.Marshal(map[string]interface{}{
-		"id":             task.ID,
-		"result":         result,
-		"operation_time": duration,
-	})
-	log.Printf("agent %d ended work with task %d, operation time: %v", n, res.Task.ID, duration)
-	http.Post("http://localhost:"+a.Port+"/internal/task", "application/json", bytes.NewBuffer(body))
+	var result float64
+	var bigResult string
+	var duration time.Duration
+	var err error
+	computed := make(chan struct{})
+	// The orchestrator sets the time of the operation, the configured one is
+	// used with orchestrators that do not.
+	operationTime := task.OperationTime
+	if operationTime <= 0 {
+		operationTime = a.operationTime(task.Operation)
+	}
+	go func() {
+		defer close(computed)
+		switch {
+		case task.Function != "":
+			result, duration, err = a.callFunction(ctx, task, module)
+		case task.Precision == client.PrecisionBig:
+			result, bigResult, duration, err = calculateBig(task, operationTime)
+		default:
+			result, duration, err = calculate(task.Arg1, task.Arg2, task.Operation, operationTime)
+		}
+	}()
+	select {
+	case <-computed:
+		// A function stopped by the cancellation did not fail by itself.
+		if err != nil && ctx.Err() != nil {
+			span.SetStatus(codes.Error, "the agent is stopping")
+			return client.TaskResult{}, ctx.Err()
+		}
+		// The module was dropped from the cache by other tasks before the
+		// call, it is fetched again when the task is handed out again.
+		if stderrors.Is(err, errors.ErrNotCompiled) {
+			span.SetStatus(codes.Error, err.Error())
+			logger.Warn("the module of the function was dropped before the call", "digest", task.Function)
+			return client.TaskResult{}, err
+		}
+	case <-ctx.Done():
+		span.SetStatus(codes.Error, "the agent is stopping")
+		return client.TaskResult{}, ctx.Err()
+	}
+	// A function or an overflowing operation can give a value that cannot
+	// be sent as a result, so the task fails instead.
+	if err == nil && (math.IsInf(result, 0) || math.IsNaN(result)) {
+		err = errors.ErrResultInfinite
+	}
+	a.m.processed.WithLabelValues(strconv.Itoa(n)).Inc()
+	a.m.computeTime.WithLabelValues(task.Operation).Observe(duration.Seconds())
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		logger.Warn("the task cannot be computed", "operation", task.Operation, "error", err)
+		return client.TaskResult{ID: task.ID, OperationTime: duration, Error: err.Error()}, nil
+	}
+	logger.Info("ended work with the task", "operation_time", duration)
+	return client.TaskResult{ID: task.ID, Result: result, BigResult: bigResult, OperationTime: duration}, nil
+}
+
+// callFunction calls the user function of the task in the sandbox, compiling
+// its module first when it was just fetched. The time of the call is the time
+// it really took.
+func (a *Agent) callFunction(ctx context.Context, task *client.Task, module []byte) (float64, time.Duration, error) {
+	start := time.Now()
+	if module != nil {
+		if err := a.functions.Compile(ctx, task.Function, module); err != nil {
+			return 0, time.Since(start), err
+		}
+	}
+	result, err := a.functions.Call(ctx, task.Function, task.Operation, task.Arg1)
+	return result, time.Since(start), err
+}
+
+// release gives the task back to the orchestrator when the agent is stopping
+// or cannot fetch the module of its function.
+func (a *Agent) release(api *client.AgentClient, task *client.Task, logger *slog.Logger) error {
+	releaseCtx, cancel := context.WithTimeout(task.TraceContext(context.Background()), 5*time.Second)
+	defer cancel()
+	if err := api.Release(releaseCtx, task.ID); err != nil {
+		logger.Error("the task was not released", "error", err)
+		return err
+	}
+	logger.Info("the task was released")
+	return nil
 }
 
+func (a *Agent) taskAttributes(task *client.Task) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.Int("task.id", task.ID),
+		attribute.Int("expression.id", task.ExpressionID),
+		attribute.String("task.operation", task.Operation),
+		attribute.String("agent.id", a.ID),
+	)
+}
+
+// TaskCalculation computes the operation spending the time the agent is
+// configured with for it. An operation that cannot be computed gives NaN.
 func (a *Agent) TaskCalculation(arg1, arg2 float64, oper string) (float64, time.Duration) {
-	switch oper {
-	case "+":
-		<-time.After(a.TimeAddition)
-		return arg1 + arg2, a.TimeAddition
-	case "-":
-		<-time.After(a.TimeSubtraction)
-		return arg1 - arg2, a.TimeSubtraction
-	case "*":
-		<-time.After(a.TimeMultiplications)
-		return arg1 * arg2, a.TimeMultiplications
-	default:
-		<-time.After(a.TimeDivisions)
-		return arg1 / arg2, a.TimeDivisions
+	result, d, err := calculate(arg1, arg2, oper, a.operationTime(oper))
+	if err != nil {
+		return math.NaN(), d
+	}
+	return result, d
+}
+
+// operationTime returns the time the agent spends on the operation when the
+// orchestrator does not set it in the task.
+func (a *Agent) operationTime(oper string) time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.OperationTimes[oper]
+}
+
+// parseCapabilities reads a comma-separated list of the allowed values.
+func parseCapabilities(cfg *config.Config, key string, allowed ...string) []string {
+	var values []string
+	for _, value := range strings.Split(cfg.String(key, ""), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		if !slices.Contains(allowed, value) {
+			cfg.Invalid(key)
+			return nil
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func calculate(arg1, arg2 float64, oper string, d time.Duration) (float64, time.Duration, error) {
+	<-time.After(d)
+	result, err := operations.Evaluate(oper, arg1, arg2)
+	return result, d, err
+}
+
+// bigPrecision is the mantissa size in bits used for big precision tasks.
+const bigPrecision = 256
+
+// calculateBig computes the operation with a big mantissa. The arguments
+// computed by other tasks come in full precision, and the result is returned
+// both rounded to the nearest float64 and in full precision for the tasks
+// depending on it.
+func calculateBig(task *client.Task, d time.Duration) (float64, string, time.Duration, error) {
+	<-time.After(d)
+	x, err := bigArg(task.BigArg1, task.Arg1)
+	if err != nil {
+		return 0, "", d, err
+	}
+	y, err := bigArg(task.BigArg2, task.Arg2)
+	if err != nil {
+		return 0, "", d, err
+	}
+	z, err := operations.EvaluateBig(task.Operation, x, y)
+	if err != nil {
+		return 0, "", d, err
+	}
+	result, _ := z.Float64()
+	return result, z.Text('g', -1), d, nil
+}
+
+func bigArg(text string, value float64) (*big.Float, error) {
+	if text == "" {
+		return operations.BigFloat(value, bigPrecision)
 	}
+	x, _, err := big.ParseFloat(text, 10, bigPrecision, big.ToNearestEven)
+	return x, err
 }
@@ -1,21 +1,52 @@
 package agent_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"io"
 	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
 	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/agent"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
+// TestMain keeps the logs of the tested code out of the test output.
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newAgent builds an agent configured by the environment of the test.
+func newAgent(t *testing.T) *agent.Agent {
+	t.Helper()
+	a, err := agent.NewAgent(config.FromEnv())
+	if err != nil {
+		t.Fatalf("failed to create the agent: %v", err)
+	}
+	return a
+}
+
 func TestTaskCalculation(t *testing.T) {
 	t.Parallel()
 
-	log.SetOutput(io.Discard)
-	defer log.SetOutput(log.Writer())
-
-	a := agent.NewAgent()
+	a := newAgent(t)
 
 	testCases := []struct {
 		name                  string
@@ -30,7 +61,7 @@ func TestTaskCalculation(t *testing.T) {
 			arg1:                  2,
 			arg2:                  2,
 			operation:             "+",
-			expectedOperationTime: a.TimeAddition,
+			expectedOperationTime: a.OperationTimes["+"],
 			expectedResult:        4,
 		},
 		{
@@ -38,7 +69,7 @@ func TestTaskCalculation(t *testing.T) {
 			arg1:                  2,
 			arg2:                  2,
 			operation:             "-",
-			expectedOperationTime: a.TimeSubtraction,
+			expectedOperationTime: a.OperationTimes["-"],
 			expectedResult:        0,
 		},
 		{
@@ -46,7 +77,7 @@ func TestTaskCalculation(t *testing.T) {
 			arg1:                  2,
 			arg2:                  2,
 			operation:             "*",
-			expectedOperationTime: a.TimeMultiplications,
+			expectedOperationTime: a.OperationTimes["*"],
 			expectedResult:        4,
 		},
 		{
@@ -54,7 +85,7 @@ func TestTaskCalculation(t *testing.T) {
 			arg1:                  2,
 			arg2:                  2,
 			operation:             "/",
-			expectedOperationTime: a.TimeDivisions,
+			expectedOperationTime: a.OperationTimes["/"],
 			expectedResult:        1,
 		},
 	}
@@ -75,3 +106,375 @@ func TestTaskCalculation(t *testing.T) {
 		})
 	}
 }
+
+func TestTaskProcessingMetrics(t *testing.T) {
+	t.Parallel()
+
+	var posted models.ReqTask
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]models.RespTask{"task": {ID: 7, Arg1: 3, Arg2: 4, Operation: "*"}})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&posted)
+	}))
+	defer srv.Close()
+
+	a := newAgent(t)
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+	a.OperationTimes["*"] = time.Millisecond
+
+	a.TaskProcessing(context.Background(), 2)
+	if posted.ID != 7 || posted.Result != 12 {
+		t.Fatalf("invalid result: got %+v", posted)
+	}
+
+	srv.Close()
+	a.TaskProcessing(context.Background(), 2)
+
+	expected := `
+# HELP agent_fetch_errors_total Failed attempts to fetch a task from the orchestrator.
+# TYPE agent_fetch_errors_total counter
+agent_fetch_errors_total 1
+# HELP agent_tasks_processed_total Tasks computed by the worker.
+# TYPE agent_tasks_processed_total counter
+agent_tasks_processed_total{worker="2"} 1
+`
+	if err := testutil.GatherAndCompare(a.Metrics, strings.NewReader(expected), "agent_fetch_errors_total", "agent_tasks_processed_total"); err != nil {
+		t.Error(err)
+	}
+	if n, err := testutil.GatherAndCount(a.Metrics, "agent_compute_seconds"); err != nil || n != 1 {
+		t.Errorf("invalid compute time series: got %v, %v want 1", n, err)
+	}
+}
+
+func TestTaskProcessingOperationTime(t *testing.T) {
+	t.Parallel()
+
+	var operationTime time.Duration
+	var posted models.ReqTask
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]models.RespTask{"task": {ID: 1, Arg1: 1, Arg2: 2, Operation: "+", OperationTime: operationTime}})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&posted)
+	}))
+	defer srv.Close()
+
+	a := newAgent(t)
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+	a.OperationTimes["+"] = 2 * time.Millisecond
+
+	for _, ts := range []struct {
+		name     string
+		sent     time.Duration
+		expected time.Duration
+	}{
+		{"set by the orchestrator", 5 * time.Millisecond, 5 * time.Millisecond},
+		{"left to the agent", 0, 2 * time.Millisecond},
+	} {
+		operationTime = ts.sent
+		a.TaskProcessing(context.Background(), 1)
+		if posted.Result != 3 || posted.OperationTime != ts.expected {
+			t.Errorf("%s: invalid result: got %+v want operation time %v", ts.name, posted, ts.expected)
+		}
+	}
+}
+
+func TestTaskProcessingTracing(t *testing.T) {
+	t.Parallel()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var posted string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+			json.NewEncoder(w).Encode(map[string]models.RespTask{"task": {ID: 3, ExprID: 1, Arg1: 1, Arg2: 2, Operation: "+"}})
+			return
+		}
+		posted = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	a := newAgent(t)
+	a.Tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+	a.TaskProcessing(context.Background(), 1)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	for i, name := range []string{"TaskCalculation", "SubmitResult"} {
+		span := spans[i]
+		if span.Name() != name {
+			t.Errorf("invalid span name: got %q want %q", span.Name(), name)
+		}
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("span %s is not part of the task trace: %s", name, got)
+		}
+		if got := span.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+			t.Errorf("span %s is not a child of the task span: %s", name, got)
+		}
+	}
+	want := "00-" + traceID + "-" + spans[1].SpanContext().SpanID().String() + "-01"
+	if posted != want {
+		t.Errorf("invalid traceparent of the result: got %q want %q", posted, want)
+	}
+}
+
+func TestTaskProcessingRelease(t *testing.T) {
+	t.Parallel()
+
+	requests := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Path
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]models.RespTask{"task": {ID: 5, Arg1: 1, Arg2: 2, Operation: "+"}})
+		}
+	}))
+	defer srv.Close()
+
+	a := newAgent(t)
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+	a.OperationTimes["+"] = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	a.TaskProcessing(ctx, 1)
+
+	if got := <-requests; got != "GET /internal/task" {
+		t.Fatalf("invalid request: got %q want %q", got, "GET /internal/task")
+	}
+	select {
+	case got := <-requests:
+		if got != "DELETE /internal/task/5" {
+			t.Errorf("invalid request: got %q want %q", got, "DELETE /internal/task/5")
+		}
+	default:
+		t.Error("the task was not released")
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	a := newAgent(t)
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the agent did not stop")
+	}
+}
+
+func TestTaskProcessingBatch(t *testing.T) {
+	t.Parallel()
+
+	requests := make(chan string, 10)
+	results := make(chan models.ReqTasks, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.RequestURI()
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(models.RespTasks{Tasks: []models.RespTask{
+				{ID: 1, Arg1: 1, Arg2: 2, Operation: "+"},
+				{ID: 2, Arg1: 3, Arg2: 4, Operation: "*"},
+				{ID: 3, Arg1: 8, Arg2: 2, Operation: "/"},
+			}})
+		case http.MethodPost:
+			var req models.ReqTasks
+			json.NewDecoder(r.Body).Decode(&req)
+			results <- req
+			resp := models.RespResults{}
+			for _, result := range req.Results {
+				resp.Results = append(resp.Results, models.RespResult{ID: result.ID, Status: http.StatusOK})
+			}
+			resp.Results[len(resp.Results)-1] = models.RespResult{ID: 3, Status: http.StatusConflict, Error: "the task has already been solved"}
+			json.NewEncoder(w).Encode(resp)
+		}
+	}))
+	defer srv.Close()
+
+	a := newAgent(t)
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+	a.Prefetch = 3
+
+	if err := a.TaskProcessing(context.Background(), 1); err == nil {
+		t.Fatal("the rejected result is not reported")
+	}
+	if got := <-requests; got != "GET /internal/tasks?limit=3" {
+		t.Errorf("invalid request: got %q want %q", got, "GET /internal/tasks?limit=3")
+	}
+	// All the results are sent in one request.
+	if got := <-requests; got != "POST /internal/tasks" {
+		t.Errorf("invalid request: got %q want %q", got, "POST /internal/tasks")
+	}
+	req := <-results
+	expected := []float64{3, 12, 4}
+	if len(req.Results) != len(expected) {
+		t.Fatalf("invalid results: %+v", req.Results)
+	}
+	for i, result := range req.Results {
+		if result.ID != i+1 || result.Result != expected[i] {
+			t.Errorf("invalid result %d: got %+v want %v", i, result, expected[i])
+		}
+	}
+	exposition := `
+# HELP agent_result_errors_total Results that could not be delivered to or were rejected by the orchestrator.
+# TYPE agent_result_errors_total counter
+agent_result_errors_total 1
+`
+	if err := testutil.GatherAndCompare(a.Metrics, strings.NewReader(exposition), "agent_result_errors_total"); err != nil {
+		t.Errorf("the rejected result is not counted: %v", err)
+	}
+
+	// Tasks that are not computed when the agent stops are released.
+	a.OperationTimes["+"] = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	a.TaskProcessing(ctx, 1)
+	<-requests
+	for id := 1; id <= 3; id++ {
+		want := "DELETE /internal/task/" + strconv.Itoa(id)
+		select {
+		case got := <-requests:
+			if got != want {
+				t.Errorf("invalid request: got %q want %q", got, want)
+			}
+		default:
+			t.Errorf("the task %d was not released", id)
+		}
+	}
+}
+
+func TestTaskProcessingCapabilities(t *testing.T) {
+	t.Parallel()
+
+	headers := make(chan http.Header, 1)
+	var posted models.ReqTask
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			headers <- r.Header.Clone()
+			json.NewEncoder(w).Encode(map[string]models.RespTask{"task": {ID: 4, Arg1: 1, Arg2: 3, Operation: "/", Precision: "big"}})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&posted)
+	}))
+	defer srv.Close()
+
+	cfg, err := config.Load([]string{"-agent-operations=/,*", "-agent-precision=big", "-agent-capacity=5", "-time-divisions-ms=5"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	a, err := agent.NewAgent(cfg)
+	if err != nil {
+		t.Fatalf("failed to create the agent: %v", err)
+	}
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+
+	if err := a.TaskProcessing(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	header := <-headers
+	for key, want := range map[string]string{"X-Agent-Operations": "/,*", "X-Agent-Precision": "big", "X-Agent-Capacity": "5"} {
+		if got := header.Get(key); got != want {
+			t.Errorf("invalid %s header: got %q want %q", key, got, want)
+		}
+	}
+	if times := strings.Split(header.Get("X-Agent-Operation-Times"), ","); !slices.Contains(times, "/=5") {
+		t.Errorf("invalid X-Agent-Operation-Times header: got %q", times)
+	}
+	if posted.ID != 4 || posted.Result != 1.0/3 {
+		t.Errorf("invalid result: got %+v", posted)
+	}
+
+	for _, args := range [][]string{{"-agent-operations=+,%"}, {"-agent-precision=decimal"}} {
+		cfg, err := config.Load(args)
+		if err != nil {
+			t.Fatalf("failed to load the config: %v", err)
+		}
+		if _, err := agent.NewAgent(cfg); err == nil {
+			t.Errorf("%v: invalid capabilities were accepted", args)
+		}
+	}
+}
+
+func TestBigPrecision(t *testing.T) {
+	t.Parallel()
+
+	o, err := orchestrator.NewOrchestrator(config.FromEnv())
+	if err != nil {
+		t.Fatalf("failed to create the orchestrator: %v", err)
+	}
+	o.AnonymousAgents = true
+	srv := httptest.NewServer(o.Router())
+	defer srv.Close()
+
+	cfg, err := config.Load([]string{"-agent-precision=float,big"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	a, err := agent.NewAgent(cfg)
+	if err != nil {
+		t.Fatalf("failed to create the agent: %v", err)
+	}
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+
+	// In float precision 0.1+0.2 is rounded before 0.3 is subtracted, in
+	// big precision the sum is exact and only the difference is rounded.
+	testCases := []struct {
+		name      string
+		precision string
+		expected  float64
+	}{
+		{"float", "float", 5.551115123125783e-17},
+		{"big", "big", 2.7755575615628914e-17},
+	}
+	for _, ts := range testCases {
+		body, _ := json.Marshal(models.ReqAddExpr{Expression: "0.1+0.2-0.3", Precision: ts.precision})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+		r = r.WithContext(auth.WithUser(r.Context(), "alice"))
+		w := httptest.NewRecorder()
+		o.AddExpression(w, r)
+		var added models.RespAddExpr
+		json.NewDecoder(w.Body).Decode(&added)
+
+		for i := 0; i < 10; i++ {
+			if err := a.TaskProcessing(context.Background(), 1); err != nil {
+				break
+			}
+		}
+		o.Mu.Lock()
+		expr := *o.Exprs[added.ID]
+		o.Mu.Unlock()
+		if expr.Status != "resolved" || expr.Result != ts.expected {
+			t.Errorf("%s: invalid expression: got %v %v want %v", ts.name, expr.Status, expr.Result, ts.expected)
+		}
+	}
+}
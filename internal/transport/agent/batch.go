@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// processBatch leases up to Prefetch tasks in one request, computes them one
+// after another and sends all the results in one request. The orchestrator
+// hands out only as many tasks as take half of the lease together, so the
+// results arrive before the leases expire. When ctx is cancelled the tasks
+// that are not computed yet are released, and the results of the computed
+// ones are still sent. A task whose module cannot be fetched is released and
+// the others are computed.
+func (a *Agent) processBatch(ctx context.Context, api *client.AgentClient, n int, w *worker, logger *slog.Logger) (int, error) {
+	tasks, err := api.FetchBatch(ctx, a.Prefetch)
+	if err == client.ErrNoTask {
+		return 0, err
+	}
+	if err != nil {
+		logger.Debug("the tasks were not fetched", "error", err)
+		a.m.fetchErrors.Inc()
+		return 0, err
+	}
+	w.setBusy(true)
+	defer w.setBusy(false)
+	logger.Debug("a batch of tasks was fetched", "tasks", len(tasks))
+
+	results := make([]client.TaskResult, 0, len(tasks))
+	links := make([]trace.Link, 0, len(tasks))
+	var failed error
+	for i, task := range tasks {
+		taskLogger := logger.With("task_id", task.ID, "expression_id", task.ExpressionID)
+		result, err := a.compute(ctx, api, n, task, taskLogger)
+		if err != nil {
+			failed = err
+			if ctx.Err() == nil {
+				a.release(api, task, taskLogger)
+				continue
+			}
+			for _, task := range tasks[i:] {
+				a.release(api, task, logger.With("task_id", task.ID, "expression_id", task.ExpressionID))
+			}
+			break
+		}
+		results = append(results, result)
+		links = append(links, trace.LinkFromContext(task.TraceContext(context.Background())))
+	}
+	if len(results) == 0 {
+		return 0, failed
+	}
+
+	// The results are sent even when the agent is stopping, since the work
+	// is already done.
+	traceCtx, span := a.Tracer.Start(context.WithoutCancel(ctx), "SubmitResults", trace.WithSpanKind(trace.SpanKindClient), trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("tasks", len(results)), attribute.String("agent.id", a.ID)))
+	defer span.End()
+	errs, err := api.SubmitBatch(traceCtx, results)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		a.m.resultErrors.Add(float64(len(results)))
+		logger.Warn("the results were not accepted", "tasks", len(results), "error", err)
+		return 0, err
+	}
+	accepted := 0
+	for i, err := range errs {
+		if err != nil {
+			failed = err
+			a.m.resultErrors.Inc()
+			logger.Warn("the result was not accepted", "task_id", results[i].ID, "error", err)
+			continue
+		}
+		accepted++
+	}
+	return accepted, failed
+}
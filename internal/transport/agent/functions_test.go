@@ -0,0 +1,101 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/agent"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/wasm"
+)
+
+// Modules written by hand in the binary format, each with one function of
+// the type (f64) -> f64: double returns x+x and spin never returns.
+var (
+	doubleModule = []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x06, 0x01, 0x60, 0x01, 0x7c, 0x01, 0x7c,
+		0x03, 0x02, 0x01, 0x00,
+		0x07, 0x0a, 0x01, 0x06, 'd', 'o', 'u', 'b', 'l', 'e', 0x00, 0x00,
+		0x0a, 0x09, 0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x00, 0xa0, 0x0b,
+	}
+	spinModule = []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x06, 0x01, 0x60, 0x01, 0x7c, 0x01, 0x7c,
+		0x03, 0x02, 0x01, 0x00,
+		0x07, 0x08, 0x01, 0x04, 's', 'p', 'i', 'n', 0x00, 0x00,
+		0x0a, 0x12, 0x01, 0x10, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x44, 0, 0, 0, 0, 0, 0, 0, 0, 0x0b,
+	}
+)
+
+func TestTaskProcessingFunction(t *testing.T) {
+	t.Parallel()
+
+	modules := map[string][]byte{wasm.Digest(doubleModule): doubleModule, wasm.Digest(spinModule): spinModule}
+	var next atomic.Value
+	var fetched atomic.Int32
+	posted := make(chan models.ReqTask, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if digest, ok := strings.CutPrefix(r.URL.Path, "/internal/functions/"); ok {
+			fetched.Add(1)
+			w.Write(modules[digest])
+			return
+		}
+		if r.Method == http.MethodGet {
+			if r.Header.Get("X-Agent-Functions") != "true" {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]models.RespTask{"task": next.Load().(models.RespTask)})
+			return
+		}
+		var req models.ReqTask
+		json.NewDecoder(r.Body).Decode(&req)
+		posted <- req
+	}))
+	defer srv.Close()
+
+	cfg, err := config.Load([]string{"-function-timeout-ms=50"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	a, err := agent.NewAgent(cfg)
+	if err != nil {
+		t.Fatalf("failed to create the agent: %v", err)
+	}
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+
+	testCases := []struct {
+		name           string
+		task           models.RespTask
+		expectedResult float64
+		expectedError  string
+	}{
+		{"call", models.RespTask{ID: 1, Arg1: 2.5, Operation: "double", Function: wasm.Digest(doubleModule)}, 5, ""},
+		{"compiled module", models.RespTask{ID: 2, Arg1: -1, Operation: "double", Function: wasm.Digest(doubleModule)}, -2, ""},
+		{"timeout", models.RespTask{ID: 3, Arg1: 1, Operation: "spin", Function: wasm.Digest(spinModule)}, 0, "function exceeded the time limit"},
+		{"infinite result", models.RespTask{ID: 5, Arg1: 1e308, Operation: "double", Function: wasm.Digest(doubleModule)}, 0, "result is not a finite number"},
+		{"missing export", models.RespTask{ID: 4, Arg1: 1, Operation: "triple", Function: wasm.Digest(doubleModule)}, 0, "invalid WebAssembly module"},
+	}
+	for _, ts := range testCases {
+		next.Store(ts.task)
+		if err := a.TaskProcessing(context.Background(), 1); err != nil {
+			t.Fatalf("%s: unexpected error: %v", ts.name, err)
+		}
+		req := <-posted
+		if req.ID != ts.task.ID || req.Result != ts.expectedResult || !strings.HasPrefix(req.Error, ts.expectedError) || (ts.expectedError == "") != (req.Error == "") {
+			t.Errorf("%s: invalid result: %+v", ts.name, req)
+		}
+	}
+	if n := fetched.Load(); n != 2 {
+		t.Errorf("invalid number of fetched modules: got %v want %v", n, 2)
+	}
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// durationBuckets suit durations in seconds from milliseconds to minutes.
+var durationBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+type agentMetrics struct {
+	processed    *prometheus.CounterVec
+	computeTime  *prometheus.HistogramVec
+	fetchErrors  prometheus.Counter
+	resultErrors prometheus.Counter
+}
+
+// registerMetrics creates the metrics of the agent in a registry of its own,
+// so several agents in one process do not share them.
+func (a *Agent) registerMetrics() {
+	a.m = &agentMetrics{
+		processed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_tasks_processed_total",
+			Help: "Tasks computed by the worker.",
+		}, []string{"worker"}),
+		computeTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "agent_compute_seconds",
+			Help:    "Time spent computing an operation.",
+			Buckets: durationBuckets,
+		}, []string{"operation"}),
+		fetchErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "agent_fetch_errors_total",
+			Help: "Failed attempts to fetch a task from the orchestrator.",
+		}),
+		resultErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "agent_result_errors_total",
+			Help: "Results that could not be delivered to or were rejected by the orchestrator.",
+		}),
+	}
+	a.Metrics = prometheus.NewRegistry()
+	a.Metrics.MustRegister(
+		a.m.processed,
+		a.m.computeTime,
+		a.m.fetchErrors,
+		a.m.resultErrors,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "agent_workers",
+			Help: "Workers in the pool.",
+		}, func() float64 { return float64(len(a.Workers())) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "agent_workers_busy",
+			Help: "Workers computing a task.",
+		}, func() float64 { return float64(a.busyWorkers()) }),
+	)
+}
+
+// serveMetrics exposes the metrics and health of the agent on its own
+// listener.
+func (a *Agent) serveMetrics() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(a.Metrics, promhttp.HandlerOpts{}))
+	mux.HandleFunc("GET /healthz", a.Healthz)
+	mux.HandleFunc("GET /readyz", a.Readyz)
+	mux.HandleFunc("GET /workers", a.WorkersHandler)
+	srv := &http.Server{Addr: ":" + a.MetricsPort, Handler: mux}
+	go func() {
+		a.logger.Info("agent metrics are served", "port", a.MetricsPort)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			a.logger.Error("agent metrics listener stopped", "error", err)
+		}
+	}()
+	return srv
+}
+
+// Healthz reports that the agent is alive.
+func (a *Agent) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether the agent takes new tasks.
+func (a *Agent) Readyz(w http.ResponseWriter, r *http.Request) {
+	if !a.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/client"
+)
+
+// minIdleInterval is the first pause of a worker that found no task; the
+// pause doubles while there are still none, up to MaxIdleInterval.
+const minIdleInterval = 10 * time.Millisecond
+
+// WorkerStats describes one worker of the pool.
+type WorkerStats struct {
+	Worker    int           `json:"worker"`
+	Busy      bool          `json:"busy"`
+	Processed int           `json:"processed"`
+	Failed    int           `json:"failed"`
+	BusyTime  time.Duration `json:"busy_time_ns"`
+	LastTask  time.Time     `json:"last_task"`
+}
+
+// worker pulls tasks one by one until it is stopped. A stopped worker
+// finishes the task it has and then exits.
+type worker struct {
+	n     int
+	stop  chan struct{}
+	mu    sync.Mutex
+	stats WorkerStats
+}
+
+// pool holds the running workers. Only Run changes the set of workers; the
+// stats are read concurrently by the metrics listener.
+type pool struct {
+	mu      sync.Mutex
+	workers []*worker
+	wg      sync.WaitGroup
+	started int
+}
+
+// resize starts or stops workers until there are size of them. The workers
+// stopped last are the ones started last. Numbers are never reused, since
+// stopped workers may still be finishing their tasks.
+func (a *Agent) resize(ctx, work context.Context, size int) {
+	p := &a.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if size == len(p.workers) {
+		return
+	}
+	for len(p.workers) < size {
+		p.started++
+		w := &worker{n: p.started, stop: make(chan struct{})}
+		w.stats.Worker = w.n
+		p.workers = append(p.workers, w)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			a.runWorker(ctx, work, w)
+		}()
+	}
+	for len(p.workers) > size {
+		last := len(p.workers) - 1
+		close(p.workers[last].stop)
+		p.workers = p.workers[:last]
+	}
+	a.logger.Info("the number of workers was changed", "workers", size)
+}
+
+// runWorker processes tasks until the worker is stopped or ctx is cancelled.
+// Tasks in progress are released when work is cancelled. A worker that finds
+// no task, or cannot reach the orchestrator, waits before the next attempt,
+// so an idle agent does not flood the orchestrator with requests.
+func (a *Agent) runWorker(ctx, work context.Context, w *worker) {
+	var idle time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.stop:
+			return
+		case <-time.After(idle):
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		start := time.Now()
+		processed, err := a.process(work, w.n, w)
+		w.mu.Lock()
+		if processed > 0 {
+			w.stats.Processed += processed
+			w.stats.BusyTime += time.Since(start)
+			w.stats.LastTask = time.Now()
+		}
+		if err != nil && err != client.ErrNoTask {
+			w.stats.Failed++
+		}
+		w.mu.Unlock()
+
+		if processed > 0 || err == nil {
+			idle = 0
+			continue
+		}
+		idle = min(max(2*idle, minIdleInterval), a.MaxIdleInterval)
+	}
+}
+
+func (w *worker) setBusy(busy bool) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.stats.Busy = busy
+	w.mu.Unlock()
+}
+
+// Workers returns the stats of the running workers ordered by their number.
+func (a *Agent) Workers() []WorkerStats {
+	a.pool.mu.Lock()
+	defer a.pool.mu.Unlock()
+
+	stats := make([]WorkerStats, 0, len(a.pool.workers))
+	for _, w := range a.pool.workers {
+		w.mu.Lock()
+		stats = append(stats, w.stats)
+		w.mu.Unlock()
+	}
+	return stats
+}
+
+// busyWorkers returns how many workers are computing a task.
+func (a *Agent) busyWorkers() int {
+	busy := 0
+	for _, w := range a.Workers() {
+		if w.Busy {
+			busy++
+		}
+	}
+	return busy
+}
+
+// WorkersHandler serves the stats of the workers as JSON.
+func (a *Agent) WorkersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]WorkerStats{"workers": a.Workers()}); err != nil {
+		a.logger.Error("the stats of the workers were not sent", "error", err)
+	}
+}
@@ -0,0 +1,139 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+)
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	const tasks = 20
+	var mu sync.Mutex
+	handed, submitted, idleFetches := 0, 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost:
+			submitted++
+		case handed < tasks:
+			handed++
+			json.NewEncoder(w).Encode(map[string]models.RespTask{"task": {ID: handed, Arg1: 1, Arg2: 2, Operation: "+"}})
+		default:
+			idleFetches++
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	a := newAgent(t)
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+	a.ComputingPower = 3
+	a.MaxIdleInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: timed out", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	processed := func() int {
+		n := 0
+		for _, w := range a.Workers() {
+			n += w.Processed
+		}
+		return n
+	}
+	waitFor("all tasks processed", func() bool { return processed() == tasks })
+
+	workers := a.Workers()
+	if len(workers) != 3 {
+		t.Fatalf("invalid number of workers: got %d want %d", len(workers), 3)
+	}
+	for i, w := range workers {
+		if w.Worker != i+1 {
+			t.Errorf("invalid worker number: got %d want %d", w.Worker, i+1)
+		}
+	}
+	mu.Lock()
+	if submitted != tasks {
+		t.Errorf("invalid number of submitted results: got %d want %d", submitted, tasks)
+	}
+	mu.Unlock()
+
+	// Idle workers back off instead of polling in a loop.
+	mu.Lock()
+	before := idleFetches
+	mu.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	fetched := idleFetches - before
+	mu.Unlock()
+	if fetched > 3*10 {
+		t.Errorf("idle workers polled too often: %d requests in 200ms", fetched)
+	}
+
+	cfg, err := config.Load([]string{"-computing-power=1"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if err := a.Reconfigure(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor("the pool shrinks", func() bool { return len(a.Workers()) == 1 })
+
+	srvMetrics := httptest.NewServer(http.HandlerFunc(a.WorkersHandler))
+	defer srvMetrics.Close()
+	resp, err := http.Get(srvMetrics.URL)
+	if err != nil {
+		t.Fatalf("failed to get the workers: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Workers []struct {
+			Worker int `json:"worker"`
+		} `json:"workers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || len(body.Workers) != 1 {
+		t.Errorf("invalid workers response: %+v %v", body, err)
+	}
+
+	// Workers started again get new numbers, so they are not confused with
+	// the stopped ones.
+	cfg, err = config.Load([]string{"-computing-power=3"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if err := a.Reconfigure(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor("the pool grows", func() bool { return len(a.Workers()) == 3 })
+	for i, w := range a.Workers() {
+		if expected := []int{1, 4, 5}[i]; w.Worker != expected {
+			t.Errorf("invalid worker number: got %d want %d", w.Worker, expected)
+		}
+	}
+}
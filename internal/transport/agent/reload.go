@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"maps"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/operations"
+)
+
+// settings are the part of the configuration that can be changed while the
+// agent runs.
+type settings struct {
+	operationTimes map[string]time.Duration
+	computingPower int
+}
+
+// readSettings reads the time of every registered operation from the setting
+// the operation names, operations without one take their cost.
+func readSettings(cfg *config.Config) settings {
+	times := make(map[string]time.Duration)
+	for _, op := range operations.All() {
+		times[op.Symbol] = op.Cost
+		if op.Setting != "" {
+			times[op.Symbol] = cfg.Millis(op.Setting, op.Cost)
+		}
+	}
+	return settings{
+		operationTimes: times,
+		computingPower: cfg.Int("COMPUTING_POWER", 1, 1),
+	}
+}
+
+// Reconfigure applies the operation times and the number of workers from
+// cfg. When one of them is invalid nothing is changed. Tasks that are being
+// computed keep the time they started with. A new number of workers takes
+// effect at once: workers are added right away, and the removed ones finish
+// their current task first.
+func (a *Agent) Reconfigure(cfg *config.Config) error {
+	s := readSettings(cfg)
+	if err := cfg.Err(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if maps.Equal(s.operationTimes, a.OperationTimes) && s.computingPower == a.ComputingPower {
+		return nil
+	}
+	a.OperationTimes = s.operationTimes
+	if a.ComputingPower != s.computingPower {
+		a.ComputingPower = s.computingPower
+		select {
+		case a.resized <- struct{}{}:
+		default:
+		}
+	}
+	a.logger.Info("the configuration was changed",
+		"operation_times", s.operationTimes,
+		"computing_power", s.computingPower,
+	)
+	return nil
+}
+
+// watchConfig reloads the config file when it is modified or the agent gets
+// SIGHUP, until ctx is cancelled.
+func (a *Agent) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	path := a.config.Path()
+	modified := modTime(path)
+	ticker := time.NewTicker(a.ConfigReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			if m := modTime(path); !m.Equal(modified) {
+				modified = m
+			} else {
+				continue
+			}
+		}
+		cfg, err := a.config.Reload()
+		if err == nil {
+			err = a.Reconfigure(cfg)
+		}
+		if err != nil {
+			a.logger.Error("the configuration was not reloaded, the previous one is kept", "file", path, "error", err)
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,116 @@
+package agent_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/agent"
+)
+
+func TestReconfigure(t *testing.T) {
+	t.Parallel()
+
+	a := newAgent(t)
+	testCases := []struct {
+		name                string
+		args                []string
+		valid               bool
+		expectedAddition    time.Duration
+		expectedPower       int
+		expectedDivisionsMs time.Duration
+	}{
+		{"new values", []string{"-time-addition-ms=5", "-computing-power=3", "-time-divisions-ms=7"}, true, 5 * time.Millisecond, 3, 7 * time.Millisecond},
+		{"invalid power", []string{"-time-addition-ms=9", "-computing-power=0"}, false, 5 * time.Millisecond, 3, 7 * time.Millisecond},
+		{"defaults", nil, true, time.Millisecond, 1, time.Millisecond},
+	}
+	for _, ts := range testCases {
+		cfg, err := config.Load(ts.args)
+		if err != nil {
+			t.Fatalf("%s: failed to load the config: %v", ts.name, err)
+		}
+		if err := a.Reconfigure(cfg); (err == nil) != ts.valid {
+			t.Errorf("%s: unexpected error: %v", ts.name, err)
+		}
+		if a.OperationTimes["+"] != ts.expectedAddition || a.ComputingPower != ts.expectedPower || a.OperationTimes["/"] != ts.expectedDivisionsMs {
+			t.Errorf("%s: got addition %v power %d divisions %v want %v %d %v", ts.name,
+				a.OperationTimes["+"], a.ComputingPower, a.OperationTimes["/"], ts.expectedAddition, ts.expectedPower, ts.expectedDivisionsMs)
+		}
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	// The shipped variables.env sets the same settings, and the file must
+	// still change them when it is reloaded.
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, "variables.env")
+	if err := os.WriteFile(envPath, []byte("TIME_ADDITION_MS=2000\nCOMPUTING_POWER=1\n"), 0o600); err != nil {
+		t.Fatalf("failed to write the env file: %v", err)
+	}
+	path := filepath.Join(dir, "agent.yaml")
+	if err := os.WriteFile(path, []byte("time_addition_ms: 2\n"), 0o600); err != nil {
+		t.Fatalf("failed to write the config: %v", err)
+	}
+	cfg, err := config.LoadEnvFile(envPath, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	a, err := agent.NewAgent(cfg)
+	if err != nil {
+		t.Fatalf("failed to create the agent: %v", err)
+	}
+	u, _ := url.Parse(srv.URL)
+	a.Host, a.Port = u.Hostname(), u.Port()
+	a.ConfigReloadInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- a.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if _, d := a.TaskCalculation(1, 1, "+"); d != 2*time.Millisecond {
+		t.Fatalf("invalid time before the reload: got %v want %v", d, 2*time.Millisecond)
+	}
+	// An invalid file keeps the previous settings.
+	writeConfig := func(data string, modified time.Time) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("failed to write the config: %v", err)
+		}
+		os.Chtimes(path, modified, modified)
+	}
+	writeConfig("time_addition_ms: -1\n", time.Now().Add(time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	if _, d := a.TaskCalculation(1, 1, "+"); d != 2*time.Millisecond {
+		t.Fatalf("an invalid config was applied: got %v want %v", d, 2*time.Millisecond)
+	}
+
+	writeConfig("time_addition_ms: 3\ncomputing_power: 2\n", time.Now().Add(2*time.Minute))
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, d := a.TaskCalculation(1, 1, "+")
+		workers := len(a.Workers())
+		if d == 3*time.Millisecond && workers == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the config was not reloaded: got %v and %d workers want %v and 2 workers", d, workers, 3*time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,236 @@
+package orchestrator
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+)
+
+// agentTimeout is how long an agent counts as connected after its last
+// request. Idle agents ask for tasks far more often than that.
+const agentTimeout = 30 * time.Second
+
+// AgentInfo is what the orchestrator knows about an agent from its requests.
+// Operations and Precisions are the capabilities the agent advertises, nil
+// when it does not, and Capacity is the most tasks it may hold at once, zero
+// for no limit. OperationTimes is the time the agent spends on operations
+// whose tasks come without one.
+type AgentInfo struct {
+	LastSeen   time.Time
+	Completed  int
+	Operations map[string]bool
+	Precisions map[string]bool
+	Capacity   int
+	Functions  bool
+
+	OperationTimes map[string]time.Duration
+}
+
+// ParseAgentTokens reads agent credentials written as comma-separated
+// name:token pairs and returns them keyed by token.
+func ParseAgentTokens(s string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || token == "" {
+			return nil, errors.ErrVariableValue
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// agentID identifies the agent sending the request. When a client CA is
+// configured the agent must present a certificate signed by it and is named
+// by its common name. When agent tokens are configured the agent must present
+// one of them and is named by it. Otherwise the agent is trusted to name
+// itself in the X-Agent-ID header only with AnonymousAgents, and rejected
+// without it.
+func (o *Orchestrator) agentID(r *http.Request) (string, error) {
+	certName := ""
+	if o.TLSClientCA != "" {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			return "", errors.ErrUnauthorized
+		}
+		certName = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	if len(o.AgentTokens) == 0 {
+		if certName != "" {
+			return certName, nil
+		}
+		if !o.AnonymousAgents {
+			return "", errors.ErrUnauthorized
+		}
+		return r.Header.Get("X-Agent-ID"), nil
+	}
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", errors.ErrUnauthorized
+	}
+	for token, name := range o.AgentTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(presented)) == 1 {
+			return name, nil
+		}
+	}
+	return "", errors.ErrUnauthorized
+}
+
+// nextTask picks the task to hand out to the agent: a task whose lease has
+// expired comes first, then the oldest task that was never handed out. Only
+// the tasks the agent can compute are considered, the rest wait for other
+// agents. The caller must hold o.Mu.
+func (o *Orchestrator) nextTask(now time.Time, info *AgentInfo) (*Task, bool) {
+	// All the tasks up to IdTaskSolved were handed out or cancelled.
+	for task, ok := o.Tasks[o.IdTaskSolved+1]; ok && task.Status != "untouched"; task, ok = o.Tasks[o.IdTaskSolved+1] {
+		o.IdTaskSolved++
+	}
+	o.expireLeases(now)
+	// Entries of tasks resolved or leased again since are dropped on the
+	// way.
+	var found *Task
+	kept := o.expired[:0]
+	for _, id := range o.expired {
+		task, ok := o.Tasks[id]
+		if !ok || task.Status != "solved" || task.leased {
+			continue
+		}
+		if found == nil && info.accepts(task) && o.argsKnown(task) {
+			found = task
+			continue
+		}
+		kept = append(kept, id)
+	}
+	o.expired = kept
+	if found != nil {
+		return found, true
+	}
+	for id := o.IdTaskSolved + 1; ; id++ {
+		task, ok := o.Tasks[id]
+		if !ok {
+			return nil, false
+		}
+		if task.Status == "untouched" && info.accepts(task) && o.argsKnown(task) {
+			return task, true
+		}
+	}
+}
+
+// ReleaseTask gives a leased task back before its lease expires, so an agent
+// that stops in the middle of the work does not hold it until the timeout.
+func (o *Orchestrator) ReleaseTask(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	agent, err := o.agentID(r)
+	if err != nil {
+		logger.Warn("an unauthenticated agent was rejected", "remote_addr", r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	logger = logger.With("agent_id", agent, "task_id", id)
+
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	o.seeAgent(agent, time.Now())
+	task, ok := o.Tasks[id]
+	if !ok {
+		logger.Warn("the task was not found to be released")
+		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if task.Status == "resolved" {
+		http.Error(w, errors.ErrTaskResolved.Error(), http.StatusConflict)
+		return
+	}
+	if task.Status == "cancelled" {
+		http.Error(w, errors.ErrTaskCancelled.Error(), http.StatusConflict)
+		return
+	}
+	if task.Status == "failed" {
+		http.Error(w, errors.ErrTaskFailed.Error(), http.StatusConflict)
+		return
+	}
+	if task.Status != "solved" || task.Agent != agent {
+		logger.Warn("a task leased to another agent was released", "lease_agent_id", task.Agent)
+		http.Error(w, errors.ErrLeaseMismatch.Error(), http.StatusForbidden)
+		return
+	}
+	o.releaseLease(task)
+	if task.span != nil {
+		task.span.AddEvent("released")
+	}
+	logger.Info("the task was released", "expression_id", task.ExprID)
+}
+
+// seeAgent records a request of the agent and returns its record. The caller
+// must hold o.Mu.
+func (o *Orchestrator) seeAgent(agent string, now time.Time) *AgentInfo {
+	info, ok := o.Agents[agent]
+	if !ok {
+		info = &AgentInfo{}
+		o.Agents[agent] = info
+	}
+	info.LastSeen = now
+	return info
+}
+
+// GetStatus reports the depth of the task queue and the agents that have
+// asked for tasks since the start.
+func (o *Orchestrator) GetStatus(w http.ResponseWriter, r *http.Request) {
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	now := time.Now()
+	resp := models.RespStatus{Agents: []models.RespAgent{}}
+	inFlight := make(map[string]int)
+	for _, task := range o.Tasks {
+		switch {
+		case task.Status == "untouched":
+			resp.QueueDepth++
+		case task.Status == "solved" && now.After(task.LeaseExpires):
+			// An expired lease puts the task back in the queue.
+			resp.QueueDepth++
+		case task.Status == "solved":
+			resp.InFlight++
+			inFlight[task.Agent]++
+		}
+	}
+	for id, info := range o.Agents {
+		resp.Agents = append(resp.Agents, models.RespAgent{
+			ID:             id,
+			Connected:      now.Sub(info.LastSeen) < agentTimeout,
+			LastSeen:       info.LastSeen,
+			TasksInFlight:  inFlight[id],
+			TasksCompleted: info.Completed,
+			Operations:     sortedKeys(info.Operations),
+			Precisions:     sortedKeys(info.Precisions),
+			Capacity:       info.Capacity,
+			Functions:      info.Functions,
+		})
+	}
+	sort.Slice(resp.Agents, func(i, j int) bool { return resp.Agents[i].ID < resp.Agents[j].ID })
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.FromContext(r.Context()).Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Debug("the status was successfully output")
+}
@@ -0,0 +1,293 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+func agentRequest(method, token string, body interface{}) *http.Request {
+	var reader io.Reader
+	if body != nil {
+		jsonBytes, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(jsonBytes)
+	}
+	r := httptest.NewRequest(method, "/internal/task", reader)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestTaskHandlerAgentAuthentication(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.AgentTokens = map[string]string{"token-1": "agent-1", "token-2": "agent-2"}
+	o.Tasks[1] = &orchestrator.Task{ID: 1, Arg1: 2, Arg2: 2, Operation: "+", Status: "untouched"}
+
+	testCases := []struct {
+		name               string
+		method             string
+		token              string
+		body               interface{}
+		expectedStatusCode int
+	}{
+		{"get without token", http.MethodGet, "", nil, http.StatusUnauthorized},
+		{"get with unknown token", http.MethodGet, "token-3", nil, http.StatusUnauthorized},
+		{"post without lease", http.MethodPost, "token-1", models.ReqTask{ID: 1, Result: 4}, http.StatusForbidden},
+		{"get with token", http.MethodGet, "token-1", nil, http.StatusOK},
+		{"post by another agent", http.MethodPost, "token-2", models.ReqTask{ID: 1, Result: 5}, http.StatusForbidden},
+		{"post without token", http.MethodPost, "", models.ReqTask{ID: 1, Result: 5}, http.StatusUnauthorized},
+		{"post by lease holder", http.MethodPost, "token-1", models.ReqTask{ID: 1, Result: 4}, http.StatusOK},
+		{"post twice", http.MethodPost, "token-1", models.ReqTask{ID: 1, Result: 4}, http.StatusConflict},
+	}
+	for _, ts := range testCases {
+		w := httptest.NewRecorder()
+		o.TaskHandler(w, agentRequest(ts.method, ts.token, ts.body))
+		if w.Code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, w.Code, ts.expectedStatusCode)
+		}
+	}
+	if task := o.Tasks[1]; task.Result != 4 || task.Agent != "agent-1" {
+		t.Fatalf("invalid task: result %v agent %v", task.Result, task.Agent)
+	}
+}
+
+func TestAnonymousAgents(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name               string
+		args               []string
+		expectedStatusCode int
+	}{
+		{"no credentials configured", nil, http.StatusUnauthorized},
+		{"anonymous agents allowed", []string{"-agent-auth=none"}, http.StatusOK},
+	}
+	for _, ts := range testCases {
+		cfg, err := config.Load(ts.args)
+		if err != nil {
+			t.Fatalf("%s: failed to load the config: %v", ts.name, err)
+		}
+		o, err := orchestrator.NewOrchestrator(cfg)
+		if err != nil {
+			t.Fatalf("%s: failed to create the orchestrator: %v", ts.name, err)
+		}
+		o.Tasks[1] = &orchestrator.Task{ID: 1, Arg1: 2, Arg2: 2, Operation: "+", Status: "untouched"}
+		r := agentRequest(http.MethodGet, "", nil)
+		r.Header.Set("X-Agent-ID", "agent-1")
+		w := httptest.NewRecorder()
+		o.TaskHandler(w, r)
+		if w.Code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, w.Code, ts.expectedStatusCode)
+		}
+	}
+
+	cfg, err := config.Load([]string{"-agent-auth=some"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if _, err := orchestrator.NewOrchestrator(cfg); err == nil {
+		t.Error("an invalid AGENT_AUTH was accepted")
+	}
+}
+
+func TestTaskHandlerLeaseExpiration(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.AgentTokens = map[string]string{"token-1": "agent-1", "token-2": "agent-2"}
+	o.LeaseTimeout = -time.Second
+	o.Tasks[1] = &orchestrator.Task{ID: 1, Arg1: 2, Arg2: 2, Operation: "+", Status: "untouched"}
+
+	for _, token := range []string{"token-1", "token-2"} {
+		w := httptest.NewRecorder()
+		o.TaskHandler(w, agentRequest(http.MethodGet, token, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expired lease was not handed out again: got %v want %v", w.Code, http.StatusOK)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodPost, "token-1", models.ReqTask{ID: 1, Result: 4}))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("result from the previous lease holder: got %v want %v", w.Code, http.StatusForbidden)
+	}
+	w = httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodPost, "token-2", models.ReqTask{ID: 1, Result: 4}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("result from the lease holder: got %v want %v", w.Code, http.StatusOK)
+	}
+}
+
+func TestInternalRouter(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.InternalPort = "8081"
+
+	w := httptest.NewRecorder()
+	o.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("public router serves internal api: got %v want %v", w.Code, http.StatusNotFound)
+	}
+	w = httptest.NewRecorder()
+	o.InternalRouter().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/login", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("internal router serves public api: got %v want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestParseAgentTokens(t *testing.T) {
+	t.Parallel()
+
+	tokens, err := orchestrator.ParseAgentTokens("agent-1:secret-1, agent-2:secret-2")
+	if err != nil {
+		t.Fatalf("failed to parse tokens: %v", err)
+	}
+	if len(tokens) != 2 || tokens["secret-1"] != "agent-1" || tokens["secret-2"] != "agent-2" {
+		t.Fatalf("invalid tokens: %v", tokens)
+	}
+	if _, err := orchestrator.ParseAgentTokens("agent-1"); err == nil {
+		t.Fatalf("token without a name was accepted")
+	}
+}
+
+func TestTaskHandlerClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.TLSClientCA = "ca.crt"
+	o.Tasks[1] = &orchestrator.Task{ID: 1, Arg1: 2, Arg2: 2, Operation: "+", Status: "untouched"}
+
+	w := httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodGet, "", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("request without a client certificate: got %v want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	r := agentRequest(http.MethodGet, "", nil)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "agent-1"}}}}}
+	w = httptest.NewRecorder()
+	o.TaskHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("request with a client certificate: got %v want %v", w.Code, http.StatusOK)
+	}
+	if agent := o.Tasks[1].Agent; agent != "agent-1" {
+		t.Fatalf("invalid lease holder: got %v want %v", agent, "agent-1")
+	}
+}
+
+func TestReleaseTask(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.AgentTokens = map[string]string{"token-1": "agent-1", "token-2": "agent-2"}
+	o.Tasks[1] = &orchestrator.Task{ID: 1, Arg1: 2, Arg2: 2, Operation: "+", Status: "untouched"}
+	router := o.Router()
+
+	release := func(token, id string) int {
+		r := httptest.NewRequest(http.MethodDelete, "/internal/task/"+id, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, agentRequest(http.MethodGet, "token-1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("the task was not handed out: got %v want %v", w.Code, http.StatusOK)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, agentRequest(http.MethodGet, "token-2", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("a leased task was handed out: got %v want %v", w.Code, http.StatusNotFound)
+	}
+
+	testCases := []struct {
+		name               string
+		token              string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"unknown token", "token-3", "1", http.StatusUnauthorized},
+		{"unknown task", "token-1", "2", http.StatusNotFound},
+		{"another agent", "token-2", "1", http.StatusForbidden},
+		{"lease holder", "token-1", "1", http.StatusOK},
+	}
+	for _, ts := range testCases {
+		if code := release(ts.token, ts.id); code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, code, ts.expectedStatusCode)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, agentRequest(http.MethodGet, "token-2", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("the released task was not handed out again: got %v want %v", w.Code, http.StatusOK)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, agentRequest(http.MethodPost, "token-2", models.ReqTask{ID: 1, Result: 4}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("result from the new lease holder: got %v want %v", w.Code, http.StatusOK)
+	}
+	if code := release("token-2", "1"); code != http.StatusConflict {
+		t.Errorf("release of a resolved task: got %v want %v", code, http.StatusConflict)
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.AgentTokens = map[string]string{"token-1": "agent-1", "token-2": "agent-2"}
+	submit(o, "alice", "2+2*3-4/5")
+
+	w := httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodGet, "token-1", nil))
+	w = httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodPost, "token-1", models.ReqTask{ID: 1, Result: 6}))
+	w = httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodGet, "token-2", nil))
+	o.Agents["agent-3"] = &orchestrator.AgentInfo{LastSeen: time.Now().Add(-time.Hour)}
+
+	w = httptest.NewRecorder()
+	o.GetStatus(w, httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	var resp models.RespStatus
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.QueueDepth != 2 || resp.InFlight != 1 {
+		t.Errorf("invalid queue: got depth %d in flight %d want 2 and 1", resp.QueueDepth, resp.InFlight)
+	}
+	expected := []models.RespAgent{
+		{ID: "agent-1", Connected: true, TasksInFlight: 0, TasksCompleted: 1},
+		{ID: "agent-2", Connected: true, TasksInFlight: 1, TasksCompleted: 0},
+		{ID: "agent-3", Connected: false, TasksInFlight: 0, TasksCompleted: 0},
+	}
+	if len(resp.Agents) != len(expected) {
+		t.Fatalf("got %d agents want %d", len(resp.Agents), len(expected))
+	}
+	for i, want := range expected {
+		got := resp.Agents[i]
+		if got.ID != want.ID || got.Connected != want.Connected || got.TasksInFlight != want.TasksInFlight || got.TasksCompleted != want.TasksCompleted {
+			t.Errorf("invalid agent %d: got %+v want %+v", i, got, want)
+		}
+	}
+}
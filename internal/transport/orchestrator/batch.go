@@ -0,0 +1,115 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TasksHandler is the batched variant of TaskHandler. GET leases up to limit
+// tasks at once, each with its own lease, as long as the agent computes them
+// in no more than half of LeaseTimeout together. POST accepts several results,
+// answering with the outcome of each of them.
+func (o *Orchestrator) TasksHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	agent, err := o.agentID(r)
+	if err != nil {
+		logger.Warn("an unauthenticated agent was rejected", "remote_addr", r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var resp interface{}
+	switch r.Method {
+	case http.MethodGet:
+		limit := o.BatchLimit
+		if s := r.URL.Query().Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+				http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			limit = min(limit, o.BatchLimit)
+		}
+
+		o.Mu.Lock()
+		defer o.Mu.Unlock()
+		now := time.Now()
+		info := o.seeAgent(agent, now)
+		o.seeCapabilities(info, r.Header)
+		if room := o.room(agent, info, now); room >= 0 {
+			limit = min(limit, room)
+		}
+		tasks := models.RespTasks{Tasks: []models.RespTask{}}
+		var cost time.Duration
+		for len(tasks.Tasks) < limit {
+			task, ok := o.nextTask(now, info)
+			if !ok {
+				break
+			}
+			// The agent computes the tasks of a batch one after another, so
+			// the batch is cut before the last leases would expire during
+			// the work. A task taken back from the expired ones is handed
+			// out first again.
+			if cost += o.taskCost(task, info); len(tasks.Tasks) > 0 && cost > o.LeaseTimeout/2 {
+				if task.Status == "solved" {
+					o.expired = append([]int{task.ID}, o.expired...)
+				}
+				break
+			}
+			header := http.Header{}
+			if task.span != nil {
+				tracing.Inject(trace.ContextWithSpan(r.Context(), task.span), header)
+			}
+			t := o.leaseTask(r.Context(), task, agent, now)
+			t.Traceparent = header.Get("traceparent")
+			tasks.Tasks = append(tasks.Tasks, t)
+		}
+		if len(tasks.Tasks) == 0 {
+			http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		resp = tasks
+	case http.MethodPost:
+		var req models.ReqTasks
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Results) == 0 || len(req.Results) > o.BatchLimit {
+			logger.Warn("an incorrect batch of results was sent", "agent_id", agent, "error", err, "results", len(req.Results))
+			http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		o.Mu.Lock()
+		defer o.Mu.Unlock()
+		info := o.seeAgent(agent, time.Now())
+		results := models.RespResults{Results: make([]models.RespResult, 0, len(req.Results))}
+		for _, result := range req.Results {
+			code, err := o.acceptResult(r.Context(), agent, info, result)
+			resp := models.RespResult{ID: result.ID, Status: code}
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			results.Results = append(results.Results, resp)
+		}
+		resp = results
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// taskCost is the time the agent spends on the task: the cost sent with the
+// task, or the time the agent advertises for the operation when the cost
+// leaves it to the agent.
+func (o *Orchestrator) taskCost(task *Task, info *AgentInfo) time.Duration {
+	if cost := o.Costs[task.Operation]; cost > 0 {
+		return cost
+	}
+	return info.OperationTimes[task.Operation]
+}
@@ -0,0 +1,145 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+func TestTasksHandler(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.AgentTokens = map[string]string{"token-1": "agent-1", "token-2": "agent-2"}
+	o.BatchLimit = 3
+	for id := 1; id <= 6; id++ {
+		o.Tasks[id] = &orchestrator.Task{ID: id, Arg1: float64(id), Arg2: 1, Operation: "+", Status: "untouched"}
+	}
+	router := o.Router()
+	call := func(method, target, token string, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			jsonBytes, _ := json.Marshal(body)
+			reader = bytes.NewReader(jsonBytes)
+		}
+		r := httptest.NewRequest(method, target, reader)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	fetch := func(target, token string) []models.RespTask {
+		w := call(http.MethodGet, target, token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: invalid status code: got %v want %v", target, w.Code, http.StatusOK)
+		}
+		var resp models.RespTasks
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Tasks
+	}
+
+	if w := call(http.MethodGet, "/internal/tasks?limit=0", "token-1", nil); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid limit: got %v want %v", w.Code, http.StatusUnprocessableEntity)
+	}
+	if tasks := fetch("/internal/tasks?limit=2", "token-1"); len(tasks) != 2 || tasks[0].ID != 1 || tasks[1].ID != 2 {
+		t.Fatalf("invalid batch: %+v", tasks)
+	}
+	// The limit is capped by BatchLimit, and only the tasks left are handed out.
+	if tasks := fetch("/internal/tasks?limit=10", "token-2"); len(tasks) != 3 || tasks[0].ID != 3 || tasks[2].ID != 5 {
+		t.Fatalf("invalid batch: %+v", tasks)
+	}
+	if tasks := fetch("/internal/tasks", "token-2"); len(tasks) != 1 || tasks[0].ID != 6 {
+		t.Fatalf("invalid batch: %+v", tasks)
+	}
+	if w := call(http.MethodGet, "/internal/tasks", "token-1", nil); w.Code != http.StatusNotFound {
+		t.Errorf("no tasks left: got %v want %v", w.Code, http.StatusNotFound)
+	}
+	for id, agent := range map[int]string{1: "agent-1", 2: "agent-1", 3: "agent-2", 6: "agent-2"} {
+		if task := o.Tasks[id]; task.Status != "solved" || task.Agent != agent || task.LeaseExpires.IsZero() {
+			t.Errorf("task %d is not leased to %s: %+v", id, agent, task)
+		}
+	}
+
+	results := models.ReqTasks{Results: []models.ReqTask{{ID: 1, Result: 2}, {ID: 3, Result: 4}, {ID: 9}}}
+	w := call(http.MethodPost, "/internal/tasks", "token-1", results)
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	var resp models.RespResults
+	json.NewDecoder(w.Body).Decode(&resp)
+	expected := []int{http.StatusOK, http.StatusForbidden, http.StatusNotFound}
+	if len(resp.Results) != len(expected) {
+		t.Fatalf("invalid results: %+v", resp.Results)
+	}
+	for i, result := range resp.Results {
+		if result.ID != results.Results[i].ID || result.Status != expected[i] || (result.Status != http.StatusOK) != (result.Error != "") {
+			t.Errorf("invalid result %d: got %+v want status %v", i, result, expected[i])
+		}
+	}
+	if o.Tasks[1].Status != "resolved" || o.Tasks[1].Result != 2 || o.Tasks[3].Status != "solved" {
+		t.Errorf("invalid tasks after the batch: %+v %+v", o.Tasks[1], o.Tasks[3])
+	}
+
+	tooMany := models.ReqTasks{Results: make([]models.ReqTask, 4)}
+	for _, body := range []interface{}{models.ReqTasks{}, tooMany} {
+		if w := call(http.MethodPost, "/internal/tasks", "token-2", body); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("invalid batch was accepted: got %v want %v", w.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
+
+func TestTasksHandlerLeaseTime(t *testing.T) {
+	t.Parallel()
+
+	// The time of an operation is the cost set on the orchestrator, or the
+	// time the agent advertises when the cost is left to the agent.
+	testCases := []struct {
+		name   string
+		costs  map[string]time.Duration
+		header string
+	}{
+		{"costs", map[string]time.Duration{"+": time.Second, "*": 10 * time.Second}, ""},
+		{"advertised times", map[string]time.Duration{"+": 0, "*": 0}, "+=1000,*=10000"},
+		{"costs over advertised times", map[string]time.Duration{"+": time.Second, "*": 0}, "+=0,*=10000"},
+	}
+	for _, ts := range testCases {
+		o := newOrchestrator(t)
+		o.LeaseTimeout = 5 * time.Second
+		for op, cost := range ts.costs {
+			o.Costs[op] = cost
+		}
+		operations := []string{"+", "+", "+", "*", "+"}
+		for i, operation := range operations {
+			o.Tasks[i+1] = &orchestrator.Task{ID: i + 1, Arg1: 1, Arg2: 1, Operation: operation, Status: "untouched"}
+		}
+		router := o.Router()
+
+		// The operations of a batch take at most half of the lease, but a
+		// task longer than that is still handed out alone.
+		for _, expected := range [][]int{{1, 2}, {3}, {4}, {5}} {
+			r := httptest.NewRequest(http.MethodGet, "/internal/tasks?limit=5", nil)
+			if ts.header != "" {
+				r.Header.Set("X-Agent-Operation-Times", ts.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			var resp models.RespTasks
+			json.NewDecoder(w.Body).Decode(&resp)
+			ids := make([]int, 0, len(resp.Tasks))
+			for _, task := range resp.Tasks {
+				ids = append(ids, task.ID)
+			}
+			if !slices.Equal(ids, expected) {
+				t.Errorf("%s: invalid batch: got %v want %v", ts.name, ids, expected)
+			}
+		}
+	}
+}
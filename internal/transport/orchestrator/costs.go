@@ -0,0 +1,134 @@
+package orchestrator
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/operations"
+)
+
+// readCosts reads the time of each registered operation from the setting
+// named by the operation with the ORCHESTRATOR_ prefix. Agents read the
+// settings without the prefix, so an agent reloading its own time is not
+// overridden by the orchestrator reading the same file; zero leaves the time
+// to the agents. When the prefixed setting is not set, the time agents are
+// configured with is sent, or the default time of the operation. A time must
+// be shorter than the lease, otherwise the task would be handed out again
+// before it is computed.
+func readCosts(cfg *config.Config, lease time.Duration) map[string]time.Duration {
+	ops := operations.All()
+	costs := make(map[string]time.Duration, len(ops))
+	for _, op := range ops {
+		costs[op.Symbol] = op.Cost
+		if op.Setting == "" {
+			continue
+		}
+		key := "ORCHESTRATOR_" + op.Setting
+		cost := cfg.Int64(key, cfg.Int64(op.Setting, op.Cost.Milliseconds(), 1), 0)
+		if cost >= lease.Milliseconds() {
+			cfg.Invalid(key)
+			continue
+		}
+		costs[op.Symbol] = time.Duration(cost) * time.Millisecond
+	}
+	return costs
+}
+
+// parseList splits a comma-separated list into a set.
+func parseList(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// requireAdmin lets through only the requests carrying ADMIN_TOKEN as the
+// bearer token. The token is not tied to a login, so registering a user never
+// grants administrator rights. Without ADMIN_TOKEN the administrator API is
+// closed.
+func (o *Orchestrator) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			http.Error(w, errors.ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+		if o.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(o.AdminToken)) != 1 {
+			logging.FromContext(r.Context()).Warn("a request without administrator rights was rejected", "remote_addr", r.RemoteAddr)
+			http.Error(w, errors.ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// costsResponse returns the cost table in milliseconds. The caller must hold
+// o.Mu.
+func (o *Orchestrator) costsResponse() models.RespCosts {
+	resp := models.RespCosts{Costs: make(map[string]int64, len(o.Costs))}
+	for op, cost := range o.Costs {
+		resp.Costs[op] = cost.Milliseconds()
+	}
+	return resp
+}
+
+func (o *Orchestrator) GetCosts(w http.ResponseWriter, r *http.Request) {
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(o.costsResponse()); err != nil {
+		logging.FromContext(r.Context()).Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetCosts changes the time of the listed operations. The new time is sent
+// with the tasks handed out from now on, including the ones already queued.
+func (o *Orchestrator) SetCosts(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	var req models.ReqCosts
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Costs) == 0 {
+		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	for op, cost := range req.Costs {
+		if _, ok := operations.Lookup(op); !ok {
+			http.Error(w, errors.ErrUnknownOperation.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if cost < 0 {
+			http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		// Comparing in milliseconds also keeps the conversion to
+		// time.Duration from overflowing.
+		if cost >= o.LeaseTimeout.Milliseconds() {
+			http.Error(w, errors.ErrCostTooLong.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	for op, cost := range req.Costs {
+		o.Costs[op] = time.Duration(cost) * time.Millisecond
+		logger.Info("the cost of the operation was changed", "operation", op, "cost", o.Costs[op])
+	}
+	if err := json.NewEncoder(w).Encode(o.costsResponse()); err != nil {
+		logger.Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,143 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+func TestCosts(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.AdminToken = "admin-token"
+	o.Costs["*"] = 2 * time.Second
+	router := o.Router()
+
+	// A user registered as admin gets an ordinary token, only ADMIN_TOKEN
+	// grants the rights.
+	request := func(method, user string, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			jsonBytes, _ := json.Marshal(body)
+			reader = bytes.NewBuffer(jsonBytes)
+		}
+		r := httptest.NewRequest(method, "/api/v1/admin/costs", reader)
+		switch user {
+		case "":
+		case "admin-token":
+			r.Header.Set("Authorization", "Bearer admin-token")
+		default:
+			token, _ := o.Auth.Issue(user)
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	testCases := []struct {
+		name               string
+		method             string
+		user               string
+		body               interface{}
+		expectedStatusCode int
+		expectedCosts      map[string]int64
+	}{
+		{"anonymous", http.MethodGet, "", nil, http.StatusUnauthorized, nil},
+		{"not an administrator", http.MethodGet, "alice", nil, http.StatusForbidden, nil},
+		{"user named admin", http.MethodGet, "admin", nil, http.StatusForbidden, nil},
+		{"read", http.MethodGet, "admin-token", nil, http.StatusOK, map[string]int64{"+": 1, "-": 1, "*": 2000, "/": 1}},
+		{"change by a user", http.MethodPut, "alice", models.ReqCosts{Costs: map[string]int64{"+": 10}}, http.StatusForbidden, nil},
+		{"unknown operation", http.MethodPut, "admin-token", models.ReqCosts{Costs: map[string]int64{"&": 10}}, http.StatusUnprocessableEntity, nil},
+		{"negative time", http.MethodPut, "admin-token", models.ReqCosts{Costs: map[string]int64{"+": -1}}, http.StatusUnprocessableEntity, nil},
+		{"empty table", http.MethodPut, "admin-token", models.ReqCosts{}, http.StatusUnprocessableEntity, nil},
+		{"longer than the lease", http.MethodPut, "admin-token", models.ReqCosts{Costs: map[string]int64{"+": o.LeaseTimeout.Milliseconds()}}, http.StatusUnprocessableEntity, nil},
+		{"overflowing time", http.MethodPut, "admin-token", models.ReqCosts{Costs: map[string]int64{"+": math.MaxInt64}}, http.StatusUnprocessableEntity, nil},
+		{"change", http.MethodPut, "admin-token", models.ReqCosts{Costs: map[string]int64{"+": 10, "/": 40}}, http.StatusOK, map[string]int64{"+": 10, "-": 1, "*": 2000, "/": 40}},
+	}
+	for _, ts := range testCases {
+		w := request(ts.method, ts.user, ts.body)
+		if w.Code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, w.Code, ts.expectedStatusCode)
+			continue
+		}
+		if ts.expectedCosts == nil {
+			continue
+		}
+		var resp models.RespCosts
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", ts.name, err)
+		}
+		for op, cost := range ts.expectedCosts {
+			if resp.Costs[op] != cost {
+				t.Errorf("%s: invalid cost of %s: got %v want %v", ts.name, op, resp.Costs[op], cost)
+			}
+		}
+	}
+
+	// Tasks carry the cost of their operation at the time they are handed out.
+	submit(o, "alice", "2*3+4")
+	for _, expected := range []time.Duration{2 * time.Second, 10 * time.Millisecond} {
+		w := httptest.NewRecorder()
+		o.TaskHandler(w, agentRequest(http.MethodGet, "", nil))
+		var resp map[string]models.RespTask
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["task"].OperationTime != expected {
+			t.Errorf("task %d: invalid operation time: got %v want %v", resp["task"].ID, resp["task"].OperationTime, expected)
+		}
+	}
+}
+
+func TestReadCosts(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		args          []string
+		expectedCosts map[string]time.Duration
+	}{
+		{"defaults", nil, map[string]time.Duration{"+": time.Millisecond, "*": time.Millisecond}},
+		{"own settings", []string{"-orchestrator-time-addition-ms=500", "-orchestrator-time-multiplications-ms=0"}, map[string]time.Duration{"+": 500 * time.Millisecond, "*": 0}},
+		{"agent settings", []string{"-time-addition-ms=200", "-time-multiplications-ms=300", "-orchestrator-time-multiplications-ms=400"}, map[string]time.Duration{"+": 200 * time.Millisecond, "*": 400 * time.Millisecond}},
+	}
+	for _, ts := range testCases {
+		cfg, err := config.Load(ts.args)
+		if err != nil {
+			t.Fatalf("%s: failed to load the config: %v", ts.name, err)
+		}
+		o, err := orchestrator.NewOrchestrator(cfg)
+		if err != nil {
+			t.Fatalf("%s: failed to create the orchestrator: %v", ts.name, err)
+		}
+		for op, cost := range ts.expectedCosts {
+			if o.Costs[op] != cost {
+				t.Errorf("%s: invalid cost of %s: got %v want %v", ts.name, op, o.Costs[op], cost)
+			}
+		}
+	}
+
+	cfg, err := config.Load([]string{"-lease-timeout-ms=1000", "-orchestrator-time-divisions-ms=1000"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if _, err := orchestrator.NewOrchestrator(cfg); err == nil {
+		t.Error("a cost not shorter than the lease was accepted")
+	}
+	cfg, err = config.Load([]string{"-lease-timeout-ms=1000", "-time-divisions-ms=1000"})
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if _, err := orchestrator.NewOrchestrator(cfg); err == nil {
+		t.Error("an agent time not shorter than the lease was accepted")
+	}
+}
@@ -0,0 +1,274 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/wasm"
+)
+
+// maxFunctionName bounds the length of function names.
+const maxFunctionName = 64
+
+// Function is a user-defined function of one argument. Its module is kept in
+// Modules under Digest while the function or unfinished tasks calling it
+// exist, so tasks keep working after the function is deleted.
+type Function struct {
+	Name      string
+	Owner     string
+	Digest    string
+	CreatedAt time.Time
+}
+
+// functionKey identifies the function in Functions. Every user has functions
+// of their own.
+func functionKey(owner, name string) string {
+	return owner + "\x00" + name
+}
+
+func isNameStart(c rune) bool {
+	return c == '_' || (c < unicode.MaxASCII && unicode.IsLetter(c))
+}
+
+func isNamePart(c rune) bool {
+	return isNameStart(c) || unicode.IsDigit(c)
+}
+
+// validFunctionName reports whether the name can be called in expressions.
+// Names of special numbers such as inf and nan are not allowed, since they
+// would be read as numbers.
+func validFunctionName(name string) bool {
+	if name == "" || len(name) > maxFunctionName || !isNameStart(rune(name[0])) {
+		return false
+	}
+	for _, c := range name {
+		if !isNamePart(c) {
+			return false
+		}
+	}
+	_, err := strconv.ParseFloat(name, 64)
+	return err != nil
+}
+
+// isCall reports whether the notation token is a call of a function.
+func isCall(token string) bool {
+	return strings.HasSuffix(token, "()")
+}
+
+func callName(token string) string {
+	return strings.TrimSuffix(token, "()")
+}
+
+// AddFunction registers a function of the user. The module must export a
+// function with the same name taking one f64 and returning one f64, and must
+// not import anything.
+func (o *Orchestrator) AddFunction(w http.ResponseWriter, r *http.Request) {
+	owner := auth.UserFrom(r.Context())
+	logger := logging.FromContext(r.Context()).With("user", owner)
+	// Every module is compiled here, so uploads are limited like
+	// submissions.
+	client := limitKey(r)
+	if ok, retryAfter := o.RateLimiter.Allow(client); !ok {
+		logger.Warn("function upload rate limit exceeded", "client", client)
+		tooManyRequests(w, errors.ErrRateLimited, retryAfter)
+		return
+	}
+	// The module is sent in base64, which is a third longer.
+	r.Body = http.MaxBytesReader(w, r.Body, o.MaxModuleSize*4/3+1024)
+	body, err := io.ReadAll(r.Body)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		logger.Warn("a module exceeds the limit", "limit_bytes", o.MaxModuleSize)
+		http.Error(w, errors.ErrBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req models.ReqAddFunction
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil || !validFunctionName(req.Name) || len(req.Module) == 0 {
+		logger.Warn("an incorrect function structure was sent", "error", err)
+		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if int64(len(req.Module)) > o.MaxModuleSize {
+		logger.Warn("a module exceeds the limit", "limit_bytes", o.MaxModuleSize)
+		http.Error(w, errors.ErrBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	o.Mu.Lock()
+	err = o.checkFunctionQuota(owner)
+	o.Mu.Unlock()
+	if err != nil {
+		logger.Warn("quota exceeded", "error", err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	// The module is compiled outside the lock, it may take a while.
+	if err := wasm.Validate(r.Context(), req.Module, req.Name); err != nil {
+		logger.Warn("an invalid module was sent", "function", req.Name, "error", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	key := functionKey(owner, req.Name)
+	if _, ok := o.Functions[key]; ok {
+		logger.Warn("the function already exists", "function", req.Name)
+		http.Error(w, errors.ErrFunctionExists.Error(), http.StatusConflict)
+		return
+	}
+	// Other uploads may have used up the quota during the compilation.
+	if err := o.checkFunctionQuota(owner); err != nil {
+		logger.Warn("quota exceeded", "error", err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	fn := &Function{Name: req.Name, Owner: owner, Digest: wasm.Digest(req.Module), CreatedAt: time.Now()}
+	o.Functions[key] = fn
+	o.Modules[fn.Digest] = req.Module
+	o.retainModule(fn.Digest)
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]models.RespFunction{"function": functionResponse(fn)}); err != nil {
+		logger.Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("the function was added", "function", fn.Name, "digest", fn.Digest, "size", len(req.Module))
+}
+
+// checkFunctionQuota verifies that the owner may add one more function. The
+// caller must hold o.Mu.
+func (o *Orchestrator) checkFunctionQuota(owner string) error {
+	if o.MaxFunctions < 1 {
+		return nil
+	}
+	count := 0
+	for _, fn := range o.Functions {
+		if fn.Owner == owner {
+			count++
+		}
+	}
+	if count >= o.MaxFunctions {
+		return errors.ErrFunctionQuota
+	}
+	return nil
+}
+
+// retainModule counts one more function or unfinished task using the module.
+// The caller must hold o.Mu.
+func (o *Orchestrator) retainModule(digest string) {
+	o.moduleRefs[digest]++
+}
+
+// releaseModule counts one user of the module less and drops the module when
+// nothing uses it any more. Tasks of operations have no module. The caller
+// must hold o.Mu.
+func (o *Orchestrator) releaseModule(digest string) {
+	if digest == "" {
+		return
+	}
+	if o.moduleRefs[digest]--; o.moduleRefs[digest] <= 0 {
+		delete(o.moduleRefs, digest)
+		delete(o.Modules, digest)
+	}
+}
+
+// indexModules counts the users of the modules after the state is loaded and
+// drops the modules nothing uses. The caller must hold o.Mu.
+func (o *Orchestrator) indexModules() {
+	o.moduleRefs = make(map[string]int)
+	for _, fn := range o.Functions {
+		o.retainModule(fn.Digest)
+	}
+	for _, task := range o.Tasks {
+		if task.Function != "" && (task.Status == "untouched" || task.Status == "solved") {
+			o.retainModule(task.Function)
+		}
+	}
+	for digest := range o.Modules {
+		if o.moduleRefs[digest] == 0 {
+			delete(o.Modules, digest)
+		}
+	}
+}
+
+func functionResponse(fn *Function) models.RespFunction {
+	return models.RespFunction{Name: fn.Name, Digest: fn.Digest, CreatedAt: fn.CreatedAt}
+}
+
+func (o *Orchestrator) GetFunctions(w http.ResponseWriter, r *http.Request) {
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	owner := auth.UserFrom(r.Context())
+	resp := []models.RespFunction{}
+	for _, fn := range o.Functions {
+		if fn.Owner == owner {
+			resp = append(resp, functionResponse(fn))
+		}
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+	if err := json.NewEncoder(w).Encode(map[string][]models.RespFunction{"functions": resp}); err != nil {
+		logging.FromContext(r.Context()).Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteFunction removes the function of the user. Expressions already
+// submitted still compute it.
+func (o *Orchestrator) DeleteFunction(w http.ResponseWriter, r *http.Request) {
+	owner := auth.UserFrom(r.Context())
+	name := mux.Vars(r)["name"]
+
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	key := functionKey(owner, name)
+	fn, ok := o.Functions[key]
+	if !ok {
+		http.Error(w, errors.ErrUnknownFunction.Error(), http.StatusNotFound)
+		return
+	}
+	delete(o.Functions, key)
+	o.releaseModule(fn.Digest)
+	logging.FromContext(r.Context()).Info("the function was deleted", "user", owner, "function", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetModule sends agents the module with the digest a task refers to.
+func (o *Orchestrator) GetModule(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	agent, err := o.agentID(r)
+	if err != nil {
+		logger.Warn("an unauthenticated agent was rejected", "remote_addr", r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	digest := mux.Vars(r)["digest"]
+
+	o.Mu.Lock()
+	module, ok := o.Modules[digest]
+	o.Mu.Unlock()
+	if !ok {
+		logger.Warn("an unknown module was requested", "agent_id", agent, "digest", digest)
+		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/wasm")
+	w.Write(module)
+	logger.Debug("the module was sent", "agent_id", agent, "digest", digest)
+}
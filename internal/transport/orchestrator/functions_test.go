@@ -0,0 +1,271 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+	calcerrors "github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/ratelimit"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/wasm"
+)
+
+// doubleModule exports the function double(x) = x+x.
+var doubleModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x06, 0x01, 0x60, 0x01, 0x7c, 0x01, 0x7c,
+	0x03, 0x02, 0x01, 0x00,
+	0x07, 0x0a, 0x01, 0x06, 'd', 'o', 'u', 'b', 'l', 'e', 0x00, 0x00,
+	0x0a, 0x09, 0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x00, 0xa0, 0x0b,
+}
+
+func addFunction(o *orchestrator.Orchestrator, user, name string, module []byte) int {
+	reqBody, _ := json.Marshal(models.ReqAddFunction{Name: name, Module: module})
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/functions", bytes.NewBuffer(reqBody))
+	r = r.WithContext(auth.WithUser(r.Context(), user))
+	w := httptest.NewRecorder()
+	o.AddFunction(w, r)
+	return w.Code
+}
+
+func TestAddFunction(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	testCases := []struct {
+		name               string
+		user               string
+		function           string
+		module             []byte
+		expectedStatusCode int
+	}{
+		{"valid function", "alice", "double", doubleModule, http.StatusCreated},
+		{"duplicate", "alice", "double", doubleModule, http.StatusConflict},
+		{"another user", "bob", "double", doubleModule, http.StatusCreated},
+		{"invalid name", "alice", "2x", doubleModule, http.StatusUnprocessableEntity},
+		{"number name", "alice", "inf", doubleModule, http.StatusUnprocessableEntity},
+		{"missing export", "alice", "triple", doubleModule, http.StatusUnprocessableEntity},
+		{"invalid module", "alice", "broken", []byte("not a module"), http.StatusUnprocessableEntity},
+		{"empty module", "alice", "empty", nil, http.StatusUnprocessableEntity},
+	}
+	for _, ts := range testCases {
+		if code := addFunction(o, ts.user, ts.function, ts.module); code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, code, ts.expectedStatusCode)
+		}
+	}
+
+	list := func(user string) []models.RespFunction {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/functions", nil)
+		r = r.WithContext(auth.WithUser(r.Context(), user))
+		w := httptest.NewRecorder()
+		o.GetFunctions(w, r)
+		var resp map[string][]models.RespFunction
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp["functions"]
+	}
+	remove := func(user, name string) int {
+		r := httptest.NewRequest(http.MethodDelete, "/api/v1/functions/"+name, nil)
+		r = mux.SetURLVars(r, map[string]string{"name": name})
+		r = r.WithContext(auth.WithUser(r.Context(), user))
+		w := httptest.NewRecorder()
+		o.DeleteFunction(w, r)
+		return w.Code
+	}
+
+	if fns := list("alice"); len(fns) != 1 || fns[0].Name != "double" || fns[0].Digest != wasm.Digest(doubleModule) {
+		t.Errorf("invalid functions: %+v", fns)
+	}
+	if code := remove("alice", "double"); code != http.StatusNoContent {
+		t.Errorf("deleting the function: got %v want %v", code, http.StatusNoContent)
+	}
+	if code := remove("alice", "double"); code != http.StatusNotFound {
+		t.Errorf("deleting a deleted function: got %v want %v", code, http.StatusNotFound)
+	}
+	if fns := list("alice"); len(fns) != 0 {
+		t.Errorf("the deleted function is listed: %+v", fns)
+	}
+	if fns := list("bob"); len(fns) != 1 {
+		t.Errorf("the function of another user was deleted: %+v", fns)
+	}
+}
+
+func TestFunctionExpression(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	addFunction(o, "alice", "double", doubleModule)
+
+	if res := submit(o, "bob", "double(2)"); res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("function of another user: got %v want %v", res.StatusCode, http.StatusUnprocessableEntity)
+	}
+	if res := submit(o, "alice", "double 2"); res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("name without a call: got %v want %v", res.StatusCode, http.StatusUnprocessableEntity)
+	}
+	if res := submit(o, "alice", "double(2+3)*2"); res.StatusCode != http.StatusCreated {
+		t.Fatalf("valid call: got %v want %v", res.StatusCode, http.StatusCreated)
+	}
+	if rpn := o.Exprs[1].Body; rpn != "double(2+3)*2" {
+		t.Errorf("invalid body: %v", rpn)
+	}
+
+	fetch := func(functions bool) (models.RespTask, int) {
+		r := agentRequest(http.MethodGet, "", nil)
+		if functions {
+			r.Header.Set("X-Agent-Functions", "true")
+		}
+		w := httptest.NewRecorder()
+		o.TaskHandler(w, r)
+		var resp map[string]models.RespTask
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp["task"], w.Code
+	}
+	post := func(req models.ReqTask) {
+		w := httptest.NewRecorder()
+		o.TaskHandler(w, agentRequest(http.MethodPost, "", req))
+		if w.Code != http.StatusOK {
+			t.Fatalf("invalid status code of the result: got %v want %v", w.Code, http.StatusOK)
+		}
+	}
+
+	task, code := fetch(false)
+	if code != http.StatusOK || task.Operation != "+" {
+		t.Fatalf("invalid first task: %v %+v", code, task)
+	}
+	post(models.ReqTask{ID: task.ID, Result: 5})
+	if _, code := fetch(false); code != http.StatusNotFound {
+		t.Fatalf("a function task was handed out to an agent without functions: got %v", code)
+	}
+	task, code = fetch(true)
+	if code != http.StatusOK || task.Operation != "double" || task.Function != wasm.Digest(doubleModule) || task.Arg1 != 5 {
+		t.Fatalf("invalid function task: %v %+v", code, task)
+	}
+	if _, code := fetch(true); code != http.StatusNotFound {
+		t.Fatalf("a task waiting for the function was handed out: got %v", code)
+	}
+	post(models.ReqTask{ID: task.ID, Result: 10})
+	task, code = fetch(false)
+	if code != http.StatusOK || task.Operation != "*" || task.Arg1 != 10 || task.Arg2 != 2 {
+		t.Fatalf("invalid task after the function: %v %+v", code, task)
+	}
+	post(models.ReqTask{ID: task.ID, Result: 20})
+	if expr := o.Exprs[1]; expr.Status != "resolved" || expr.Result != 20 {
+		t.Errorf("invalid expression: %+v", expr)
+	}
+}
+
+func TestFailedTask(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	addFunction(o, "alice", "double", doubleModule)
+	submit(o, "alice", "double(1)+double(2)")
+
+	r := agentRequest(http.MethodGet, "", nil)
+	r.Header.Set("X-Agent-Functions", "true")
+	w := httptest.NewRecorder()
+	o.TaskHandler(w, r)
+	var resp map[string]models.RespTask
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	w = httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodPost, "", models.ReqTask{ID: resp["task"].ID, Error: "function exceeded the time limit"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status code of the error: got %v want %v", w.Code, http.StatusOK)
+	}
+	w = httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodPost, "", models.ReqTask{ID: resp["task"].ID, Result: 2}))
+	if w.Code != http.StatusConflict {
+		t.Errorf("result of the failed task: got %v want %v", w.Code, http.StatusConflict)
+	}
+	expr := o.Exprs[1]
+	if expr.Status != "failed" || expr.Error != "function exceeded the time limit" || expr.FinishedAt.IsZero() {
+		t.Fatalf("invalid expression: %+v", expr)
+	}
+	for _, id := range expr.TaskIDs {
+		if status := o.Tasks[id].Status; status != "failed" && status != "cancelled" {
+			t.Errorf("task %v was left %q", id, status)
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/expressions/1/cancel", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "1"})
+	r = r.WithContext(auth.WithUser(r.Context(), "alice"))
+	w = httptest.NewRecorder()
+	o.CancelExpression(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("cancelling a failed expression: got %v want %v", w.Code, http.StatusConflict)
+	}
+}
+
+func TestFunctionLimits(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.MaxFunctions = 1
+	o.RateLimiter = ratelimit.New(0.001, 2)
+
+	testCases := []struct {
+		name               string
+		function           string
+		expectedStatusCode int
+		expectedError      error
+	}{
+		{"within the quota", "double", http.StatusCreated, nil},
+		{"over the quota", "twice", http.StatusTooManyRequests, calcerrors.ErrFunctionQuota},
+		{"over the rate limit", "twice", http.StatusTooManyRequests, calcerrors.ErrRateLimited},
+	}
+	for _, ts := range testCases {
+		reqBody, _ := json.Marshal(models.ReqAddFunction{Name: ts.function, Module: doubleModule})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/functions", bytes.NewBuffer(reqBody))
+		r = r.WithContext(auth.WithUser(r.Context(), "alice"))
+		w := httptest.NewRecorder()
+		o.AddFunction(w, r)
+		if w.Code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, w.Code, ts.expectedStatusCode)
+		}
+		if ts.expectedError != nil && strings.TrimSpace(w.Body.String()) != ts.expectedError.Error() {
+			t.Errorf("%s: invalid error: got %q want %q", ts.name, w.Body.String(), ts.expectedError)
+		}
+	}
+}
+
+func TestModuleCollection(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	digest := wasm.Digest(doubleModule)
+	addFunction(o, "alice", "double", doubleModule)
+	addFunction(o, "bob", "double", doubleModule)
+	submit(o, "alice", "double(2)")
+
+	remove := func(user string) {
+		r := httptest.NewRequest(http.MethodDelete, "/api/v1/functions/double", nil)
+		r = mux.SetURLVars(r, map[string]string{"name": "double"})
+		r = r.WithContext(auth.WithUser(r.Context(), user))
+		o.DeleteFunction(httptest.NewRecorder(), r)
+	}
+	remove("alice")
+	remove("bob")
+	if _, ok := o.Modules[digest]; !ok {
+		t.Fatal("the module of an unfinished task was dropped")
+	}
+
+	r := agentRequest(http.MethodGet, "", nil)
+	r.Header.Set("X-Agent-Functions", "true")
+	o.TaskHandler(httptest.NewRecorder(), r)
+	w := httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodPost, "", models.ReqTask{ID: 1, Result: 4}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status code of the result: got %v want %v", w.Code, http.StatusOK)
+	}
+	if _, ok := o.Modules[digest]; ok {
+		t.Error("the module nothing uses was kept")
+	}
+}
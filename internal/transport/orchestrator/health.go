@@ -0,0 +1,21 @@
+package orchestrator
+
+import (
+	"net/http"
+)
+
+// Healthz reports that the process is alive.
+func (o *Orchestrator) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether the orchestrator accepts work. It fails before the
+// listeners are started and once the shutdown begins, so load balancers stop
+// sending requests while the connections are drained.
+func (o *Orchestrator) Readyz(w http.ResponseWriter, r *http.Request) {
+	if !o.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
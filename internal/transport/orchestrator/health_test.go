@@ -0,0 +1,85 @@
+package orchestrator_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	router := o.Router()
+	for _, ts := range []struct {
+		path               string
+		expectedStatusCode int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ts.path, nil))
+		if w.Code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.path, w.Code, ts.expectedStatusCode)
+		}
+	}
+}
+
+func TestRunShutdown(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	o := newOrchestrator(t)
+	o.Port = port
+	o.InternalPort = ""
+	o.StateFile = filepath.Join(t.TempDir(), "state.json")
+	submit(o, "", "2+2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- o.Run(ctx) }()
+
+	ready := false
+	for i := 0; i < 100 && !ready; i++ {
+		resp, err := http.Get("http://127.0.0.1:" + port + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			ready = resp.StatusCode == http.StatusOK
+		}
+		if !ready {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if !ready {
+		t.Fatal("the server did not become ready")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not stop")
+	}
+	if _, err := http.Get("http://127.0.0.1:" + port + "/healthz"); err == nil {
+		t.Error("the server still accepts connections")
+	}
+	if _, err := os.Stat(o.StateFile); err != nil {
+		t.Errorf("the state was not saved: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package orchestrator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+)
+
+type idempotencyRecord struct {
+	hash        [sha256.Size]byte
+	done        bool
+	statusCode  int
+	contentType string
+	body        []byte
+	expiresAt   time.Time
+}
+
+// IdempotencyStore remembers responses to requests sent with an
+// Idempotency-Key header so that retries can be answered without repeating
+// side effects.
+type IdempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	records map[string]*idempotencyRecord
+	swept   time.Time
+	now     func() time.Time
+}
+
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		ttl:     ttl,
+		records: make(map[string]*idempotencyRecord),
+		now:     time.Now,
+	}
+}
+
+// begin reserves the key for a request with the given body. It returns the
+// stored record when the request was already processed, nil when the caller
+// should process it, or an error when the key is reused with another body or
+// the original request is still in progress.
+func (s *IdempotencyStore) begin(key string, body []byte) (*idempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	hash := sha256.Sum256(body)
+	rec, ok := s.records[key]
+	if !ok || rec.expired(now) {
+		s.records[key] = &idempotencyRecord{hash: hash}
+		return nil, nil
+	}
+	if rec.hash != hash {
+		return nil, errors.ErrIdempotencyConflict
+	}
+	if !rec.done {
+		return nil, errors.ErrIdempotencyInProgress
+	}
+	return rec, nil
+}
+
+// sweep forgets the expired records, which begin would replace anyway. It
+// runs at most once a minute.
+func (s *IdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for key, rec := range s.records {
+		if rec.expired(now) {
+			delete(s.records, key)
+		}
+	}
+}
+
+func (rec *idempotencyRecord) expired(now time.Time) bool {
+	return rec.done && now.After(rec.expiresAt)
+}
+
+// complete stores the response for the key. Server-side failures and
+// exceeded limits are not remembered so that the client can retry them.
+func (s *IdempotencyStore) complete(key string, capture *responseCapture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if capture.statusCode >= http.StatusInternalServerError || capture.statusCode == http.StatusTooManyRequests {
+		delete(s.records, key)
+		return
+	}
+	rec, ok := s.records[key]
+	if !ok {
+		return
+	}
+	rec.done = true
+	rec.statusCode = capture.statusCode
+	rec.contentType = capture.Header().Get("Content-Type")
+	rec.body = capture.body.Bytes()
+	rec.expiresAt = s.now().Add(s.ttl)
+}
+
+func (rec *idempotencyRecord) replay(w http.ResponseWriter) {
+	if rec.contentType != "" {
+		w.Header().Set("Content-Type", rec.contentType)
+	}
+	w.WriteHeader(rec.statusCode)
+	w.Write(rec.body)
+}
+
+// responseCapture passes the response through while keeping a copy of the
+// status code and body.
+type responseCapture struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(statusCode int) {
+	if !c.wroteHeader {
+		c.statusCode = statusCode
+		c.wroteHeader = true
+	}
+	c.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
@@ -0,0 +1,39 @@
+package orchestrator
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStoreSweep(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	s := NewIdempotencyStore(time.Second)
+	s.now = func() time.Time { return now }
+
+	store := func(key string) {
+		if _, err := s.begin(key, []byte("2+2")); err != nil {
+			t.Fatalf("%s: unexpected error: %v", key, err)
+		}
+		s.complete(key, &responseCapture{ResponseWriter: httptest.NewRecorder(), statusCode: 201})
+	}
+	store("alice")
+	store("bob")
+
+	now = now.Add(time.Minute)
+	if rec, err := s.begin("alice", []byte("3+3")); rec != nil || err != nil {
+		t.Fatalf("expired record was honoured: got %v, %v", rec, err)
+	}
+	if _, ok := s.records["bob"]; ok {
+		t.Fatalf("expired record was not forgotten")
+	}
+
+	store("carol")
+	now = now.Add(2 * time.Second)
+	s.begin("dave", nil)
+	if _, ok := s.records["carol"]; !ok {
+		t.Fatalf("records were swept more than once a minute")
+	}
+}
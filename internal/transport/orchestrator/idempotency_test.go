@@ -0,0 +1,102 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/ratelimit"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+func TestAddExpressionIdempotency(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+
+	send := func(key, expr string) (int, models.RespAddExpr) {
+		reqBody, _ := json.Marshal(models.ReqAddExpr{Expression: expr})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(reqBody))
+		r.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+
+		o.AddExpression(w, r)
+		res := w.Result()
+		defer res.Body.Close()
+
+		var resp models.RespAddExpr
+		json.NewDecoder(res.Body).Decode(&resp)
+		return res.StatusCode, resp
+	}
+
+	code, first := send("key-1", "2+2")
+	if code != http.StatusCreated {
+		t.Fatalf("invalid status code: got %v want %v", code, http.StatusCreated)
+	}
+
+	code, repeated := send("key-1", "2+2")
+	if code != http.StatusCreated {
+		t.Fatalf("invalid status code on retry: got %v want %v", code, http.StatusCreated)
+	}
+	if repeated.ID != first.ID {
+		t.Fatalf("invalid id on retry: got %v want %v", repeated.ID, first.ID)
+	}
+	if len(o.Exprs) != 1 || len(o.Tasks) != 1 {
+		t.Fatalf("retry created duplicates: %d expressions, %d tasks", len(o.Exprs), len(o.Tasks))
+	}
+
+	if code, _ := send("key-1", "3+3"); code != http.StatusConflict {
+		t.Fatalf("invalid status code for reused key: got %v want %v", code, http.StatusConflict)
+	}
+
+	if code, _ := send("key-2", "2+$"); code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid status code: got %v want %v", code, http.StatusUnprocessableEntity)
+	}
+	if code, _ := send("key-2", "2+$"); code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid status code on retry: got %v want %v", code, http.StatusUnprocessableEntity)
+	}
+
+	if code, other := send("key-3", "2+2"); code != http.StatusCreated || other.ID == first.ID {
+		t.Fatalf("new key must create a new expression: got status %v id %v", code, other.ID)
+	}
+
+	// Retries answered from the store do not spend the rate limit, and a
+	// request over the limit can be retried with the same key.
+	o.RateLimiter = ratelimit.New(0.001, 1)
+	for i := 0; i < 3; i++ {
+		if code, _ := send("key-1", "2+2"); code != http.StatusCreated {
+			t.Fatalf("invalid status code on retry %d: got %v want %v", i, code, http.StatusCreated)
+		}
+	}
+	if code, _ := send("key-4", "5+5"); code != http.StatusCreated {
+		t.Fatalf("request within the limit: got %v want %v", code, http.StatusCreated)
+	}
+	if code, _ := send("key-5", "6+6"); code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: got %v want %v", code, http.StatusTooManyRequests)
+	}
+	o.RateLimiter = ratelimit.New(0.001, 1)
+	if code, _ := send("key-5", "6+6"); code != http.StatusCreated {
+		t.Fatalf("retry of a limited request: got %v want %v", code, http.StatusCreated)
+	}
+}
+
+func TestIdempotencyKeyExpiration(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.Idempotency = orchestrator.NewIdempotencyStore(0)
+
+	for i := 0; i < 2; i++ {
+		reqBody, _ := json.Marshal(models.ReqAddExpr{Expression: "2+2"})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(reqBody))
+		r.Header.Set("Idempotency-Key", "key")
+		w := httptest.NewRecorder()
+		o.AddExpression(w, r)
+	}
+	if len(o.Exprs) != 2 {
+		t.Fatalf("expired key must not be honoured: got %d expressions want 2", len(o.Exprs))
+	}
+}
@@ -0,0 +1,91 @@
+package orchestrator
+
+import (
+	"container/heap"
+	"time"
+)
+
+// lease is an entry of the lease queue. It is stale once the task is no
+// longer leased until expires, the queue drops such entries lazily.
+type lease struct {
+	id      int
+	expires time.Time
+}
+
+// leaseQueue orders the leases by their end, so expired leases are found
+// without scanning all tasks.
+type leaseQueue []lease
+
+func (q leaseQueue) Len() int { return len(q) }
+
+func (q leaseQueue) Less(i, j int) bool {
+	if q[i].expires.Equal(q[j].expires) {
+		return q[i].id < q[j].id
+	}
+	return q[i].expires.Before(q[j].expires)
+}
+
+func (q leaseQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *leaseQueue) Push(x interface{}) { *q = append(*q, x.(lease)) }
+
+func (q *leaseQueue) Pop() interface{} {
+	old := *q
+	l := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return l
+}
+
+// startLease counts the lease of the task, which leaseTask has just set. The
+// caller must hold o.Mu.
+func (o *Orchestrator) startLease(task *Task) {
+	task.leased = true
+	o.held[task.Agent]++
+	heap.Push(&o.leases, lease{id: task.ID, expires: task.LeaseExpires})
+}
+
+// endLease stops counting the lease of the task when it is resolved, failed,
+// cancelled or released. The caller must hold o.Mu.
+func (o *Orchestrator) endLease(task *Task) {
+	if !task.leased {
+		return
+	}
+	task.leased = false
+	if o.held[task.Agent]--; o.held[task.Agent] <= 0 {
+		delete(o.held, task.Agent)
+	}
+}
+
+// expireLeases moves the tasks whose leases ended by now to the queue of
+// tasks handed out again. The caller must hold o.Mu.
+func (o *Orchestrator) expireLeases(now time.Time) {
+	for len(o.leases) > 0 && now.After(o.leases[0].expires) {
+		l := heap.Pop(&o.leases).(lease)
+		task, ok := o.Tasks[l.id]
+		if !ok || !task.leased || task.Status != "solved" || !task.LeaseExpires.Equal(l.expires) {
+			continue
+		}
+		o.endLease(task)
+		o.expired = append(o.expired, task.ID)
+	}
+}
+
+// releaseLease ends the lease of the task before it expires. The task is the
+// first one handed out again. The caller must hold o.Mu.
+func (o *Orchestrator) releaseLease(task *Task) {
+	o.endLease(task)
+	task.LeaseExpires = time.Time{}
+	o.expired = append([]int{task.ID}, o.expired...)
+}
+
+// indexLeases rebuilds the lease queue and the counts of leases from the
+// tasks, after they are loaded. The caller must hold o.Mu.
+func (o *Orchestrator) indexLeases() {
+	o.leases, o.expired, o.held = nil, nil, make(map[string]int)
+	for _, task := range o.Tasks {
+		task.leased = false
+		if task.Status == "solved" {
+			o.startLease(task)
+		}
+	}
+}
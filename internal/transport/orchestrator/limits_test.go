@@ -0,0 +1,125 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/ratelimit"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+func TestAddExpressionLimits(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.Limits = orchestrator.Limits{
+		MaxBodyBytes: 200,
+		MaxLength:    60,
+		MaxTokens:    20,
+		MaxDepth:     3,
+		MaxTasks:     4,
+	}
+
+	testCases := []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedError      error
+	}{
+		{
+			name:               "within limits",
+			body:               `{"expression":"((1+2)*3)-4"}`,
+			expectedStatusCode: http.StatusCreated,
+		},
+		{
+			name:               "body too large",
+			body:               `{"expression":"1+2","padding":"` + strings.Repeat("x", 200) + `"}`,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+			expectedError:      errors.ErrBodyTooLarge,
+		},
+		{
+			name:               "expression too long",
+			body:               `{"expression":"1` + strings.Repeat(" ", 60) + `+2"}`,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedError:      errors.ErrExpressionTooLong,
+		},
+		{
+			name:               "too many tokens",
+			body:               `{"expression":"1-1-1-1-1-1-1-1-1-1-1"}`,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedError:      errors.ErrTooManyTokens,
+		},
+		{
+			name:               "nesting too deep",
+			body:               `{"expression":"((((1+2))))"}`,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedError:      errors.ErrNestingTooDeep,
+		},
+		{
+			name:               "too many tasks",
+			body:               `{"expression":"1-2-3-4-5-6"}`,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedError:      errors.ErrTooManyTasks,
+		},
+	}
+	for _, ts := range testCases {
+		ts := ts
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(ts.body))
+			w := httptest.NewRecorder()
+			o.AddExpression(w, r)
+
+			if w.Code != ts.expectedStatusCode {
+				t.Fatalf("invalid status code: got %v want %v", w.Code, ts.expectedStatusCode)
+			}
+			if ts.expectedError != nil && strings.TrimSpace(w.Body.String()) != ts.expectedError.Error() {
+				t.Fatalf("invalid error: got %q want %q", w.Body.String(), ts.expectedError.Error())
+			}
+		})
+	}
+}
+
+func FuzzToPolishNotation(f *testing.F) {
+	for _, seed := range []string{"2+2+2", "1+(2-3)*4/5", "1+(-2.2)-3*4.45/(-5)", "2.1+4*7.5.2", "(2+3)*(5+3", "2+3*(5+6-1))", "-", "(-)", "()", "1--2", "0*(1/0)"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, expr string) {
+		rpn, err := orchestrator.ToPolishNotationLimited(expr, orchestrator.Limits{MaxTokens: 500, MaxDepth: 50})
+		if err != nil {
+			return
+		}
+		tree, err := orchestrator.BuildTree(rpn)
+		if err != nil {
+			return
+		}
+		optimized := orchestrator.Optimize(tree)
+		if _, err := orchestrator.BuildTree(optimized.RPN()); err != nil {
+			t.Fatalf("optimized form of %q can not be parsed back: %v", expr, err)
+		}
+	})
+}
+
+func FuzzAddExpression(f *testing.F) {
+	for _, seed := range []string{"2+2", "(1+2)*1+0*(3-4)", "2+6-7/0", "((((((1))))))", "1+2+3+4+5+6+7+8"} {
+		f.Add(seed)
+	}
+	o := newOrchestrator(f)
+	o.RateLimiter = ratelimit.New(0, 1)
+	f.Fuzz(func(t *testing.T, expr string) {
+		reqBody, _ := json.Marshal(models.ReqAddExpr{Expression: expr})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(reqBody))
+		w := httptest.NewRecorder()
+		o.AddExpression(w, r)
+		if w.Code != http.StatusCreated && w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("unexpected status code %v for %q", w.Code, expr)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package orchestrator
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// durationBuckets suit durations in seconds from milliseconds to minutes.
+var durationBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+type orchestratorMetrics struct {
+	submitted       prometheus.Counter
+	resolved        prometheus.Counter
+	failed          prometheus.Counter
+	dispatchLatency prometheus.Histogram
+	turnaround      *prometheus.HistogramVec
+	requestDuration *prometheus.HistogramVec
+}
+
+// registerMetrics creates the metrics of the orchestrator in a registry of
+// its own, so several orchestrators in one process do not share them.
+func (o *Orchestrator) registerMetrics() {
+	o.m = &orchestratorMetrics{
+		submitted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "calculator_expressions_submitted_total",
+			Help: "Expressions accepted for computation.",
+		}),
+		resolved: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "calculator_expressions_resolved_total",
+			Help: "Expressions whose result was computed.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "calculator_expressions_failed_total",
+			Help: "Expressions rejected on submission or failed during computation.",
+		}),
+		dispatchLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "calculator_task_dispatch_latency_seconds",
+			Help:    "Time from task creation until it is first handed out to an agent.",
+			Buckets: durationBuckets,
+		}),
+		turnaround: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "calculator_task_turnaround_seconds",
+			Help:    "Time from handing a task out until its result is received.",
+			Buckets: durationBuckets,
+		}, []string{"operation"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "calculator_http_request_duration_seconds",
+			Help:    "Duration of HTTP requests.",
+			Buckets: durationBuckets,
+		}, []string{"route", "method", "code"}),
+	}
+	o.Metrics = prometheus.NewRegistry()
+	o.Metrics.MustRegister(
+		o.m.submitted,
+		o.m.resolved,
+		o.m.failed,
+		o.m.dispatchLatency,
+		o.m.turnaround,
+		o.m.requestDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "calculator_queue_depth",
+			Help: "Tasks waiting to be handed out to an agent.",
+		}, func() float64 { return float64(o.countTasks("untouched")) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "calculator_tasks_in_flight",
+			Help: "Tasks handed out to agents and not yet resolved.",
+		}, func() float64 { return float64(o.countTasks("solved")) }),
+	)
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+func (o *Orchestrator) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(o.Metrics, promhttp.HandlerOpts{})
+}
+
+func (o *Orchestrator) countTasks(status string) int {
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	n := 0
+	for _, task := range o.Tasks {
+		if task.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// instrument records the duration of every request by its route template.
+func (o *Orchestrator) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		o.m.requestDuration.WithLabelValues(route, r.Method, strconv.Itoa(sw.statusCode)).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,87 @@
+package orchestrator_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	submit(o, "", "2+2")
+	submit(o, "", "2+3")
+	submit(o, "", "2+$")
+
+	w := httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodGet, "", nil))
+	w = httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodPost, "", models.ReqTask{ID: 1, Result: 4}))
+
+	o.AdminToken = "admin-token"
+	router := o.Router()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil))
+
+	// The metrics are served only to the administrator.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid status code without a token: got %v want %v", w.Code, http.StatusUnauthorized)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), "calculator_expressions_submitted_total 2\n") {
+		t.Errorf("the metrics are missing from:\n%s", w.Body.String())
+	}
+
+	expected := `
+# HELP calculator_expressions_failed_total Expressions rejected on submission or failed during computation.
+# TYPE calculator_expressions_failed_total counter
+calculator_expressions_failed_total 1
+# HELP calculator_expressions_resolved_total Expressions whose result was computed.
+# TYPE calculator_expressions_resolved_total counter
+calculator_expressions_resolved_total 1
+# HELP calculator_expressions_submitted_total Expressions accepted for computation.
+# TYPE calculator_expressions_submitted_total counter
+calculator_expressions_submitted_total 2
+# HELP calculator_queue_depth Tasks waiting to be handed out to an agent.
+# TYPE calculator_queue_depth gauge
+calculator_queue_depth 1
+# HELP calculator_tasks_in_flight Tasks handed out to agents and not yet resolved.
+# TYPE calculator_tasks_in_flight gauge
+calculator_tasks_in_flight 0
+`
+	names := []string{
+		"calculator_expressions_failed_total",
+		"calculator_expressions_resolved_total",
+		"calculator_expressions_submitted_total",
+		"calculator_queue_depth",
+		"calculator_tasks_in_flight",
+	}
+	if err := testutil.GatherAndCompare(o.Metrics, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+
+	// The durations vary, so only the series of the histograms are counted.
+	// Requests are recorded by route, method and status code, the two
+	// requests for the metrics and the one for the expressions.
+	for name, want := range map[string]int{
+		"calculator_task_dispatch_latency_seconds": 1,
+		"calculator_task_turnaround_seconds":       1,
+		"calculator_http_request_duration_seconds": 3,
+	} {
+		if got, err := testutil.GatherAndCount(o.Metrics, name); err != nil || got != want {
+			t.Errorf("invalid series of %s: got %v, %v want %v", name, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package orchestrator
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/operations"
+)
+
+// Node is an expression tree node. Leaves hold a number and have an empty
+// Operation. A call of a user function has its argument in Left and no Right.
+type Node struct {
+	Operation string
+	Value     float64
+	Left      *Node
+	Right     *Node
+	// height caches the depth of the tree plus one, zero means unknown.
+	// Trees are never changed after they are built, rewrites make new
+	// nodes.
+	height int
+}
+
+// BuildTree turns a reverse polish notation into an expression tree.
+func BuildTree(rpn []string) (*Node, error) {
+	stack := []*Node{}
+	for _, oper := range rpn {
+		num, err := strconv.ParseFloat(oper, 64)
+		if err == nil {
+			stack = append(stack, &Node{Value: num})
+			continue
+		}
+		if isCall(oper) {
+			if len(stack) < 1 {
+				return nil, errors.ErrInvalidData
+			}
+			stack[len(stack)-1] = &Node{Operation: oper, Left: stack[len(stack)-1]}
+			continue
+		}
+		if _, ok := operations.Lookup(oper); len(stack) < 2 || !ok {
+			return nil, errors.ErrInvalidData
+		}
+		left, right := stack[len(stack)-2], stack[len(stack)-1]
+		stack = append(stack[:len(stack)-2], &Node{Operation: oper, Left: left, Right: right})
+	}
+	if len(stack) != 1 {
+		return nil, errors.ErrInvalidData
+	}
+	return stack[0], nil
+}
+
+// RPN returns the reverse polish notation of the tree.
+func (n *Node) RPN() []string {
+	if n.Operation == "" {
+		return []string{strconv.FormatFloat(n.Value, 'g', -1, 64)}
+	}
+	if n.Right == nil {
+		return append(n.Left.RPN(), n.Operation)
+	}
+	return append(append(n.Left.RPN(), n.Right.RPN()...), n.Operation)
+}
+
+// String returns the infix form of the tree with only the brackets needed to
+// keep its structure.
+func (n *Node) String() string {
+	var sb strings.Builder
+	n.write(&sb)
+	return sb.String()
+}
+
+func (n *Node) write(sb *strings.Builder) {
+	if n.Operation == "" {
+		if n.Value < 0 {
+			sb.WriteString("(" + strconv.FormatFloat(n.Value, 'g', -1, 64) + ")")
+		} else {
+			sb.WriteString(strconv.FormatFloat(n.Value, 'g', -1, 64))
+		}
+		return
+	}
+	if n.Right == nil {
+		sb.WriteString(callName(n.Operation) + "(")
+		n.Left.write(sb)
+		sb.WriteString(")")
+		return
+	}
+	op, _ := operations.Lookup(n.Operation)
+	writeOperand(sb, n.Left, n.Left.needsBrackets(op, operations.Right))
+	sb.WriteString(n.Operation)
+	writeOperand(sb, n.Right, n.Right.needsBrackets(op, operations.Left))
+}
+
+// needsBrackets reports whether the operand of the parent operation must be
+// bracketed: when it binds looser, or as loose on the side the parent does
+// not group towards, like the right operand of a-(b-c).
+func (n *Node) needsBrackets(parent operations.Operation, side operations.Associativity) bool {
+	if n.Operation == "" || n.Right == nil {
+		return false
+	}
+	op, _ := operations.Lookup(n.Operation)
+	return op.Precedence < parent.Precedence || (op.Precedence == parent.Precedence && parent.Associativity == side)
+}
+
+func writeOperand(sb *strings.Builder, n *Node, brackets bool) {
+	if brackets {
+		sb.WriteString("(")
+	}
+	n.write(sb)
+	if brackets {
+		sb.WriteString(")")
+	}
+}
+
+func (n *Node) equal(other *Node) bool {
+	if n.Operation != other.Operation {
+		return false
+	}
+	if n.Operation == "" {
+		return n.Value == other.Value
+	}
+	if n.Right == nil || other.Right == nil {
+		return n.Right == other.Right && n.Left.equal(other.Left)
+	}
+	return n.Left.equal(other.Left) && n.Right.equal(other.Right)
+}
+
+func (n *Node) depth() int {
+	if n.height == 0 {
+		switch {
+		case n.Operation == "":
+			n.height = 1
+		case n.Right == nil:
+			n.height = 2 + n.Left.depth()
+		default:
+			n.height = 2 + max(n.Left.depth(), n.Right.depth())
+		}
+	}
+	return n.height - 1
+}
+
+// evaluate computes the value of the tree locally. It is used to make sure a
+// subtree can be dropped without hiding a division by zero. User functions
+// run only on agents, so a tree calling one cannot be computed and is never
+// dropped.
+func (n *Node) evaluate() (float64, error) {
+	if n.Operation == "" {
+		return n.Value, nil
+	}
+	if n.Right == nil {
+		return 0, errors.ErrUnknownFunction
+	}
+	arg1, err := n.Left.evaluate()
+	if err != nil {
+		return 0, err
+	}
+	arg2, err := n.Right.evaluate()
+	if err != nil {
+		return 0, err
+	}
+	return operations.Evaluate(n.Operation, arg1, arg2)
+}
+
+func isConst(n *Node, value float64) bool {
+	return n.Operation == "" && n.Value == value
+}
+
+// droppable reports whether the subtree can be removed from the expression
+// without changing whether the expression is valid. Rewrites like x-x=0 and
+// 0*x=0 do not hold for infinities and NaN, so their value must be finite.
+func droppable(n *Node) bool {
+	value, err := n.evaluate()
+	return err == nil && finite(value)
+}
+
+func nonZero(n *Node) bool {
+	value, err := n.evaluate()
+	return err == nil && finite(value) && value != 0
+}
+
+func finite(value float64) bool {
+	return !math.IsInf(value, 0) && !math.IsNaN(value)
+}
+
+// Optimize simplifies the tree and rebalances long chains of associative
+// operations, such as additions and multiplications, so that their
+// operations can be computed in parallel. Rebalancing regroups the operands,
+// which changes how floating-point results are rounded: 0.1+0.2+0.3 is
+// computed as 0.1+(0.2+0.3).
+func Optimize(n *Node) *Node {
+	return rebalance(Simplify(n))
+}
+
+// Simplify applies safe algebraic rewrites to the tree, which do not change
+// its result. Operations on two arbitrary numbers are left to the agents.
+func Simplify(n *Node) *Node {
+	if n.Operation == "" {
+		return n
+	}
+	if n.Right == nil {
+		return &Node{Operation: n.Operation, Left: Simplify(n.Left)}
+	}
+	left, right := Simplify(n.Left), Simplify(n.Right)
+	switch n.Operation {
+	case "+":
+		if isConst(right, 0) {
+			return left
+		}
+		if isConst(left, 0) {
+			return right
+		}
+	case "-":
+		if isConst(right, 0) {
+			return left
+		}
+		if left.equal(right) && droppable(left) {
+			return &Node{Value: 0}
+		}
+	case "*":
+		if isConst(right, 1) {
+			return left
+		}
+		if isConst(left, 1) {
+			return right
+		}
+		if (isConst(left, 0) && droppable(right)) || (isConst(right, 0) && droppable(left)) {
+			return &Node{Value: 0}
+		}
+	case "/":
+		if isConst(right, 1) {
+			return left
+		}
+		if isConst(left, 0) && nonZero(right) {
+			return &Node{Value: 0}
+		}
+		if left.equal(right) && nonZero(left) {
+			return &Node{Value: 1}
+		}
+	}
+	return &Node{Operation: n.Operation, Left: left, Right: right}
+}
+
+func rebalance(n *Node) *Node {
+	if n.Operation == "" {
+		return n
+	}
+	if n.Right == nil {
+		return &Node{Operation: n.Operation, Left: rebalance(n.Left)}
+	}
+	if op, _ := operations.Lookup(n.Operation); !op.Associative {
+		return &Node{Operation: n.Operation, Left: rebalance(n.Left), Right: rebalance(n.Right)}
+	}
+	operands := n.chain(n.Operation, nil)
+	for i, operand := range operands {
+		operands[i] = rebalance(operand)
+	}
+	balanced := balance(n.Operation, operands)
+	if balanced.depth() < n.depth() {
+		return balanced
+	}
+	// The operands are rebalanced already, so they are put back in the
+	// original shape instead of rebalancing the subtrees once more.
+	return n.rebuild(n.Operation, &operands)
+}
+
+// rebuild copies the chain replacing its operands, in the order chain
+// collected them, with the given ones.
+func (n *Node) rebuild(operation string, operands *[]*Node) *Node {
+	if n.Operation != operation {
+		operand := (*operands)[0]
+		*operands = (*operands)[1:]
+		return operand
+	}
+	left := n.Left.rebuild(operation, operands)
+	return &Node{Operation: operation, Left: left, Right: n.Right.rebuild(operation, operands)}
+}
+
+// chain collects the operands of consecutive operations of the same kind in
+// their original order.
+func (n *Node) chain(operation string, operands []*Node) []*Node {
+	if n.Operation != operation {
+		return append(operands, n)
+	}
+	return n.Right.chain(operation, n.Left.chain(operation, operands))
+}
+
+func balance(operation string, operands []*Node) *Node {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	mid := len(operands) / 2
+	return &Node{Operation: operation, Left: balance(operation, operands[:mid]), Right: balance(operation, operands[mid:])}
+}
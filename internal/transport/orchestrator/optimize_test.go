@@ -0,0 +1,207 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+func TestOptimize(t *testing.T) {
+	t.Parallel()
+
+	// inf is the product of huge by itself, which overflows.
+	huge := "1" + strings.Repeat("0", 200)
+	inf := "(" + huge + "*" + huge + ")"
+
+	testCases := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{
+			name:     "multiplication by one",
+			expr:     "(2+3)*1",
+			expected: "2+3",
+		},
+		{
+			name:     "multiplication by zero",
+			expr:     "0*(2+3*4)",
+			expected: "0",
+		},
+		{
+			name:     "multiplication by zero hiding division by zero",
+			expr:     "0*(2/0)",
+			expected: "0*(2/0)",
+		},
+		{
+			name:     "self subtraction of infinity",
+			expr:     inf + "-" + inf,
+			expected: "1e+200*1e+200-1e+200*1e+200",
+		},
+		{
+			name:     "multiplication of infinity by zero",
+			expr:     "0*" + inf,
+			expected: "0*(1e+200*1e+200)",
+		},
+		{
+			name:     "multiplication by zero of infinity",
+			expr:     inf + "*0",
+			expected: "1e+200*1e+200*0",
+		},
+		{
+			name:     "self division of infinity",
+			expr:     inf + "/" + inf,
+			expected: "1e+200*1e+200/(1e+200*1e+200)",
+		},
+		{
+			name:     "division of zero by infinity",
+			expr:     "0/" + inf,
+			expected: "0/(1e+200*1e+200)",
+		},
+		{
+			name:     "self subtraction",
+			expr:     "(2+3)-(2+3)+4",
+			expected: "4",
+		},
+		{
+			name:     "self division",
+			expr:     "(2-3)/(2-3)",
+			expected: "1",
+		},
+		{
+			name:     "addition of zero",
+			expr:     "0+2-0",
+			expected: "2",
+		},
+		{
+			name:     "long addition chain",
+			expr:     "1+2+3+4+5+6+7+8",
+			expected: "1+2+(3+4)+(5+6+(7+8))",
+		},
+		{
+			name:     "subtraction is not rebalanced",
+			expr:     "1-2-3-4",
+			expected: "1-2-3-4",
+		},
+		{
+			name:     "negative numbers",
+			expr:     "(-2)*(-3)/1",
+			expected: "(-2)*(-3)",
+		},
+	}
+	for _, ts := range testCases {
+		ts := ts
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+
+			rpn, err := orchestrator.ToPolishNotation(ts.expr)
+			if err != nil {
+				t.Fatalf("invalid expression: %v", err)
+			}
+			tree, err := orchestrator.BuildTree(rpn)
+			if err != nil {
+				t.Fatalf("invalid expression: %v", err)
+			}
+			if got := orchestrator.Optimize(tree).String(); got != ts.expected {
+				t.Fatalf("invalid optimized form: got %v want %v", got, ts.expected)
+			}
+		})
+	}
+}
+
+func TestAddExpressionOptimization(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+
+	testCases := []struct {
+		name          string
+		req           models.ReqAddExpr
+		expectedTasks int
+		optimized     string
+	}{
+		{
+			name:          "optimized",
+			req:           models.ReqAddExpr{Expression: "(1+2)*1+0*(3-4)"},
+			expectedTasks: 1,
+			optimized:     "1+2",
+		},
+		{
+			name:          "optimization disabled",
+			req:           models.ReqAddExpr{Expression: "(5+6)*1+0*(7-8)", DisableOptimization: true},
+			expectedTasks: 5,
+			optimized:     "(5+6)*1+0*(7-8)",
+		},
+		{
+			name:          "rebalanced",
+			req:           models.ReqAddExpr{Expression: "0.1+0.2+0.3+0.4"},
+			expectedTasks: 3,
+			optimized:     "0.1+0.2+(0.3+0.4)",
+		},
+		{
+			name:          "big precision is not rebalanced",
+			req:           models.ReqAddExpr{Expression: "0.1+0.2+0.3+0.4+1*5", Precision: "big"},
+			expectedTasks: 4,
+			optimized:     "0.1+0.2+0.3+0.4+5",
+		},
+	}
+	for _, ts := range testCases {
+		reqBody, _ := json.Marshal(ts.req)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(reqBody))
+		w := httptest.NewRecorder()
+		o.AddExpression(w, r)
+
+		var added models.RespAddExpr
+		json.NewDecoder(w.Result().Body).Decode(&added)
+		if got := len(o.Exprs[added.ID].TaskIDs); got != ts.expectedTasks {
+			t.Errorf("%s: invalid task count: got %v want %v", ts.name, got, ts.expectedTasks)
+		}
+
+		r = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/1/optimized", nil)
+		r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(added.ID)})
+		w = httptest.NewRecorder()
+		o.GetOptimizedExpression(w, r)
+
+		var resp struct {
+			Expression models.RespOptimized `json:"expression"`
+		}
+		json.NewDecoder(w.Result().Body).Decode(&resp)
+		if resp.Expression.Optimized != ts.optimized {
+			t.Errorf("%s: invalid optimized form: got %v want %v", ts.name, resp.Expression.Optimized, ts.optimized)
+		}
+		if resp.Expression.Optimization == ts.req.DisableOptimization {
+			t.Errorf("%s: invalid optimization flag: got %v", ts.name, resp.Expression.Optimization)
+		}
+	}
+}
+
+func TestOptimizeAlternatingChain(t *testing.T) {
+	t.Parallel()
+
+	expr := "7" + strings.Repeat("-2+3", 200)
+	rpn, err := orchestrator.ToPolishNotation(expr)
+	if err != nil {
+		t.Fatalf("invalid expression: %v", err)
+	}
+	tree, err := orchestrator.BuildTree(rpn)
+	if err != nil {
+		t.Fatalf("invalid expression: %v", err)
+	}
+	start := time.Now()
+	got := orchestrator.Optimize(tree).String()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the optimization is too slow: %v", elapsed)
+	}
+	if got != expr {
+		t.Errorf("invalid optimized form: got %v want %v", got, expr)
+	}
+}
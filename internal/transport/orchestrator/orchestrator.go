@@ -1,83 +1,266 @@
 package orchestrator
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
 	"encoding/json"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/cache"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
 	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
 	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/operations"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/ratelimit"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tlsutil"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Orchestrator struct {
-	Port         string
-	Exprs        map[int]*Expression
-	Tasks        map[int]*Task
-	Mu           sync.Mutex
-	IdExpr       int
-	IdTask       int
-	IdTaskSolved int
+	Port   string
+	Exprs  map[int]*Expression
+	Tasks  map[int]*Task
+	Agents map[string]*AgentInfo
+	// Costs is the time agents spend on each operation, sent with every
+	// task. Zero leaves the time to the agent.
+	Costs         map[string]time.Duration
+	AdminToken    string
+	Users         map[string]*User
+	Functions     map[string]*Function
+	Modules       map[string][]byte
+	MaxModuleSize int64
+	Auth          *auth.Issuer
+	InternalPort  string
+	AgentTokens   map[string]string
+	LeaseTimeout  time.Duration
+	BatchLimit    int
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSClientCA   string
+	certs         *tlsutil.Reloader
+	Mu            sync.Mutex
+	IdExpr        int
+	IdTask        int
+	IdTaskSolved  int
+	Idempotency   *IdempotencyStore
+	Results       *cache.Cache[TaskKey, float64]
+	Deduplicated  int
+	Optimization  bool
+	RateLimiter   *ratelimit.Limiter
+	MaxUnresolved int
+	MaxTasks      int
+	MaxFunctions  int
+	Limits        Limits
+	Metrics       *prometheus.Registry
+	m             *orchestratorMetrics
+	Tracer        trace.Tracer
+	// StateFile keeps expressions, tasks and users between restarts.
+	StateFile       string
+	ShutdownTimeout time.Duration
+	// AnonymousAgents lets agents name themselves in the X-Agent-ID header
+	// when neither agent tokens nor a client CA are configured. Otherwise
+	// such agents are rejected, since anyone could send results.
+	AnonymousAgents bool
+	ready           atomic.Bool
+	// leases orders the leased tasks by the end of their leases, expired
+	// holds the tasks whose leases ended in the order they are handed out
+	// again, and held counts the leases of each agent.
+	leases  leaseQueue
+	expired []int
+	held    map[string]int
+	// moduleRefs counts the functions and the unfinished tasks using each
+	// module, a module nothing uses is dropped.
+	moduleRefs map[string]int
+	// usage counts the unresolved expressions and tasks of each owner, so
+	// the quotas are checked without scanning all expressions.
+	usage map[string]usage
 }
 
-func NewOrchestrator() *Orchestrator {
-	godotenv.Load("variables.env")
-	port := os.Getenv("PORT")
-	intPort, err := strconv.Atoi(port)
-	if port == "" || err != nil {
-		port = "8080"
+// Limits bounds the size and complexity of submitted expressions. Zero
+// values disable the corresponding limit.
+type Limits struct {
+	MaxBodyBytes int64
+	MaxLength    int
+	MaxTokens    int
+	MaxDepth     int
+	MaxTasks     int
+}
+
+// NewOrchestrator builds an orchestrator from the settings of cfg. Invalid
+// settings are reported together in the returned error.
+func NewOrchestrator(cfg *config.Config) (*Orchestrator, error) {
+	port := cfg.Port("PORT", "8080")
+	secret := []byte(cfg.Secret("JWT_SECRET", ""))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+		slog.Warn("JWT_SECRET is not set, tokens will not survive a restart")
+	}
+	internalPort := cfg.Port("INTERNAL_PORT", "")
+	if internalPort == port {
+		cfg.Invalid("INTERNAL_PORT")
+	}
+	agentTokens, err := ParseAgentTokens(cfg.Secret("AGENT_TOKENS", ""))
+	if err != nil {
+		cfg.Invalid("AGENT_TOKENS")
 	}
-	if intPort < 0 || intPort > 9999 {
-		port = "8080"
+	leaseTimeout := cfg.Millis("LEASE_TIMEOUT_MS", 5*time.Minute)
+	agentAuth := cfg.String("AGENT_AUTH", "")
+	if agentAuth != "" && agentAuth != "none" {
+		cfg.Invalid("AGENT_AUTH")
 	}
-	return &Orchestrator{
-		Port:   port,
-		Exprs:  make(map[int]*Expression),
-		Tasks:  make(map[int]*Task),
-		IdExpr: 1,
-		IdTask: 1,
+	o := &Orchestrator{
+		Port:          port,
+		Optimization:  cfg.Bool("OPTIMIZATION", true),
+		Exprs:         make(map[int]*Expression),
+		Tasks:         make(map[int]*Task),
+		Agents:        make(map[string]*AgentInfo),
+		held:          make(map[string]int),
+		moduleRefs:    make(map[string]int),
+		usage:         make(map[string]usage),
+		Costs:         readCosts(cfg, leaseTimeout),
+		AdminToken:    cfg.Secret("ADMIN_TOKEN", ""),
+		Users:         make(map[string]*User),
+		Functions:     make(map[string]*Function),
+		Modules:       make(map[string][]byte),
+		MaxModuleSize: cfg.Int64("MAX_MODULE_BYTES", 1<<20, 1),
+		Auth:          auth.NewIssuer(secret, cfg.Millis("JWT_TTL_MS", 24*time.Hour)),
+		InternalPort:  internalPort,
+		AgentTokens:   agentTokens,
+		LeaseTimeout:  leaseTimeout,
+		BatchLimit:    cfg.Int("TASK_BATCH_LIMIT", 64, 1),
+		TLSCertFile:   cfg.String("TLS_CERT_FILE", ""),
+		TLSKeyFile:    cfg.String("TLS_KEY_FILE", ""),
+		TLSClientCA:   cfg.String("TLS_CLIENT_CA_FILE", ""),
+		IdExpr:        1,
+		IdTask:        1,
+		Idempotency:   NewIdempotencyStore(cfg.Millis("IDEMPOTENCY_TTL_MS", 24*time.Hour)),
+		Results:       cache.New[TaskKey, float64](cfg.Int("CACHE_SIZE", 1024, 0), cfg.Millis("CACHE_TTL_MS", time.Hour)),
+		RateLimiter:   ratelimit.New(cfg.Float("RATE_LIMIT_RPS", 10, 0), cfg.Int("RATE_LIMIT_BURST", 20, 1)),
+		MaxUnresolved: cfg.Int("QUOTA_MAX_UNRESOLVED", 0, 0),
+		MaxTasks:      cfg.Int("QUOTA_MAX_TASKS", 0, 0),
+		MaxFunctions:  cfg.Int("QUOTA_MAX_FUNCTIONS", 20, 0),
+		Limits: Limits{
+			MaxBodyBytes: cfg.Int64("MAX_BODY_BYTES", 64*1024, 0),
+			MaxLength:    cfg.Int("MAX_EXPRESSION_LENGTH", 10000, 0),
+			MaxTokens:    cfg.Int("MAX_TOKENS", 2000, 0),
+			MaxDepth:     cfg.Int("MAX_NESTING_DEPTH", 100, 0),
+			MaxTasks:     cfg.Int("MAX_EXPRESSION_TASKS", 1000, 0),
+		},
+		Tracer:          tracing.Tracer(),
+		StateFile:       cfg.String("STATE_FILE", ""),
+		ShutdownTimeout: cfg.Millis("SHUTDOWN_TIMEOUT_MS", 10*time.Second),
+		AnonymousAgents: agentAuth == "none",
 	}
+	if err := cfg.Err(); err != nil {
+		return nil, err
+	}
+	o.registerMetrics()
+	if o.StateFile != "" {
+		if err := o.LoadState(o.StateFile); err == nil {
+			slog.Info("the state was restored", "file", o.StateFile, "expressions", len(o.Exprs), "tasks", len(o.Tasks))
+		} else if !os.IsNotExist(err) {
+			slog.Error("the state was not restored", "file", o.StateFile, "error", err)
+		}
+	}
+	return o, nil
 }
 
 type Expression struct {
-	ID        int
-	Status    string
-	Result    float64
-	Body      string
-	EndTaskID int
+	ID           int
+	Status       string
+	Result       float64
+	Body         string
+	Owner        string
+	Optimized    string
+	Optimization bool
+	Precision    string
+	EndTaskID    int
+	TaskIDs      []int
+	Error        string
+	CreatedAt    time.Time
+	StartedAt    time.Time
+	FinishedAt   time.Time
+}
+
+// TaskKey identifies the result of an operation by its content, so equal
+// operations can share one result. Results computed in different precision
+// modes are kept apart.
+type TaskKey struct {
+	Operation string
+	Function  string
+	Arg1      float64
+	Arg2      float64
+	Precision string
 }
 
 type Task struct {
 	ID            int
+	ExprID        int
+	Deps          []int
 	Arg1          float64
 	Arg2          float64
 	Operation     string
+	Precision     string
 	OperationTime time.Duration
 	Status        string
 	Result        float64
+	Agent         string
+	LeaseExpires  time.Time
+	QueuedAt      time.Time
+	DispatchedAt  time.Time
+	// Function is the digest of the module of a user function, called by
+	// the name in Operation.
+	Function string
+	// ArgTasks are the tasks whose results become Arg1 and Arg2 when the
+	// task is leased. They are set for the arguments computed by user
+	// functions, which are not known when the expression is submitted, and
+	// for all arguments computed by tasks in big precision.
+	ArgTasks [2]int
+	// BigResult is the result of a big precision task in full precision,
+	// in decimal.
+	BigResult string
+	// span lasts from queueing the task until its result is accepted.
+	span trace.Span
+	// leased is set while the lease of the task is counted in held.
+	leased bool
 }
 
-var (
-	precedence = map[rune]int{
-		'+': 1,
-		'-': 1,
-		'*': 2,
-		'/': 2,
-	}
-)
-
 func ToPolishNotation(expression string) ([]string, error) {
+	return ToPolishNotationLimited(expression, Limits{})
+}
+
+// ToPolishNotationLimited works like ToPolishNotation but stops as soon as
+// the expression exceeds the token count or nesting depth of the limits. A
+// call of a user function, name(argument), is written as "name()" after its
+// argument.
+func ToPolishNotationLimited(expression string, limits Limits) ([]string, error) {
 	output := []string{}
-	stack := []rune{}
-	i := 0
+	stack := []string{}
+	i, tokens, depth := 0, 0, 0
 	for i < len(expression) {
+		tokens++
+		if limits.MaxTokens > 0 && tokens > limits.MaxTokens {
+			return nil, errors.ErrTooManyTokens
+		}
 		char := rune(expression[i])
 		if unicode.IsDigit(char) || (char == '-' && (i == 0 || expression[i-1] == '(')) {
 			start := i
@@ -91,158 +274,397 @@ func ToPolishNotation(expression string) ([]string, error) {
 			output = append(output, number)
 			continue
 		}
-		if !(char == '+' || char == '-' || char == '*' || char == '/' || char == '(' || char == ')') {
-			return nil, errors.ErrInvalidSymbol
+		if isNameStart(char) {
+			start := i
+			for i < len(expression) && isNamePart(rune(expression[i])) {
+				i++
+			}
+			// The name is kept on the stack until the closing bracket of
+			// its argument.
+			if i == len(expression) || expression[i] != '(' {
+				return nil, errors.ErrInvalidSymbol
+			}
+			stack = append(stack, expression[start:i]+"()")
+			continue
 		}
-		if char == '+' || char == '-' || char == '*' || char == '/' {
-			for len(stack) > 0 && precedence[stack[len(stack)-1]] >= precedence[char] {
-				output = append(output, string(stack[len(stack)-1]))
+		if op, ok := operations.Lookup(string(char)); ok {
+			for len(stack) > 0 && popsBefore(stack[len(stack)-1], op) {
+				output = append(output, stack[len(stack)-1])
 				stack = stack[:len(stack)-1]
 			}
-			stack = append(stack, char)
+			stack = append(stack, string(char))
 		} else if char == '(' {
-			stack = append(stack, char)
+			depth++
+			if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+				return nil, errors.ErrNestingTooDeep
+			}
+			stack = append(stack, "(")
 		} else if char == ')' {
-			for len(stack) > 0 && stack[len(stack)-1] != '(' {
-				output = append(output, string(stack[len(stack)-1]))
+			depth--
+			for len(stack) > 0 && stack[len(stack)-1] != "(" {
+				output = append(output, stack[len(stack)-1])
 				stack = stack[:len(stack)-1]
 			}
 			if len(stack) == 0 {
 				return nil, errors.ErrClosingBracket
 			}
 			stack = stack[:len(stack)-1]
+			if len(stack) > 0 && isCall(stack[len(stack)-1]) {
+				output = append(output, stack[len(stack)-1])
+				stack = stack[:len(stack)-1]
+			}
+		} else {
+			return nil, errors.ErrInvalidSymbol
 		}
 		i++
 	}
 	for len(stack) > 0 {
-		if stack[len(stack)-1] == '(' {
+		if stack[len(stack)-1] == "(" {
 			return nil, errors.ErrOpeningBracket
 		}
-		output = append(output, string(stack[len(stack)-1]))
+		output = append(output, stack[len(stack)-1])
 		stack = stack[:len(stack)-1]
 	}
 	return output, nil
 }
 
+// popsBefore reports whether the operator on top of the stack is applied
+// before op is pushed: when it binds tighter, or as tight and op groups to the
+// left.
+func popsBefore(top string, op operations.Operation) bool {
+	topOp, ok := operations.Lookup(top)
+	if !ok {
+		return false
+	}
+	return topOp.Precedence > op.Precedence || (topOp.Precedence == op.Precedence && op.Associativity == operations.Left)
+}
+
 func (o *Orchestrator) AddExpression(w http.ResponseWriter, r *http.Request) {
+	ctx, span := o.Tracer.Start(r.Context(), "AddExpression", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	owner := auth.UserFrom(ctx)
+	logger := logging.FromContext(ctx).With("user", owner)
+	client := limitKey(r)
+
+	if o.Limits.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, o.Limits.MaxBodyBytes)
+	}
+	body, err := io.ReadAll(r.Body)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		logger.Warn("a request body exceeds the limit", "limit_bytes", o.Limits.MaxBodyBytes)
+		http.Error(w, errors.ErrBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		logger.Warn("incorrect processing expression entered", "error", err)
+		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		o.submit(ctx, w, logger, client, owner, body)
+		return
+	}
+	rec, err := o.Idempotency.begin(owner+"\x00"+key, body)
+	if err != nil {
+		logger.Warn("idempotency key was rejected", "idempotency_key", key, "error", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if rec != nil {
+		logger.Info("repeated request was answered from the store", "idempotency_key", key)
+		rec.replay(w)
+		return
+	}
+	capture := o.submit(ctx, w, logger, client, owner, body)
+	o.Idempotency.complete(owner+"\x00"+key, capture)
+}
+
+// submit adds the expression and counts the outcome. The rate limit is
+// applied here, so repeated requests answered from the idempotency store do
+// not spend it.
+func (o *Orchestrator) submit(ctx context.Context, w http.ResponseWriter, logger *slog.Logger, client, owner string, body []byte) *responseCapture {
+	capture := &responseCapture{ResponseWriter: w, statusCode: http.StatusOK}
+	span := trace.SpanFromContext(ctx)
+	if ok, retryAfter := o.RateLimiter.Allow(client); !ok {
+		logger.Warn("submission rate limit exceeded", "client", client)
+		span.SetStatus(codes.Error, errors.ErrRateLimited.Error())
+		tooManyRequests(capture, errors.ErrRateLimited, retryAfter)
+		return capture
+	}
+	o.addExpression(ctx, capture, logger, owner, body)
+	span.SetAttributes(attribute.Int("http.response.status_code", capture.statusCode))
+	if capture.statusCode == http.StatusCreated {
+		o.m.submitted.Inc()
+	} else {
+		o.m.failed.Inc()
+		span.SetStatus(codes.Error, http.StatusText(capture.statusCode))
+	}
+	return capture
+}
+
+func (o *Orchestrator) addExpression(ctx context.Context, w http.ResponseWriter, logger *slog.Logger, owner string, body []byte) {
 	var req models.ReqAddExpr
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Expression == "" {
-		log.Println("incorrect processing expression entered")
+	if err := json.Unmarshal(body, &req); err != nil || req.Expression == "" {
+		logger.Warn("incorrect processing expression entered")
 		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
 		return
 	}
 
-	expr := strings.ReplaceAll(req.Expression, " ", "")
+	if o.Limits.MaxLength > 0 && len(req.Expression) > o.Limits.MaxLength {
+		logger.Warn("an expression exceeds the length limit", "length", len(req.Expression))
+		http.Error(w, errors.ErrExpressionTooLong.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 
-	o.Mu.Lock()
-	defer o.Mu.Unlock()
+	expr := strings.ReplaceAll(req.Expression, " ", "")
+	// Float is the default mode and is stored as an empty string, so tasks
+	// saved before precision modes existed keep their meaning.
+	precision := req.Precision
+	if precision == "float" {
+		precision = ""
+	}
+	if precision != "" && !precisions[precision] {
+		logger.Warn("an unknown precision mode was requested", "precision", req.Precision)
+		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 
-	rpn, err := ToPolishNotation(expr)
+	rpn, err := ToPolishNotationLimited(expr, o.Limits)
+	if err == errors.ErrTooManyTokens || err == errors.ErrNestingTooDeep {
+		logger.Warn("the expression exceeds the complexity limits", "error", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		logger.Warn("it is impossible to create a reverse polish notation for the expression", "expression", expr)
+		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	tree, err := BuildTree(rpn)
 	if err != nil {
-		log.Printf("it is impossible to create a reverse polish notation for the expression: %s\n", expr)
+		logger.Warn("it is impossible to create a reverse polish notation for the expression", "expression", expr)
 		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
 		return
 	}
-	stack, tasks, i := []float64{}, []*Task{}, -1
+	optimization := o.Optimization && !req.DisableOptimization
+	if optimization {
+		// Rebalancing changes how the results are rounded, which big
+		// precision is asked for to avoid.
+		if precision == "big" {
+			tree = Simplify(tree)
+		} else {
+			tree = Optimize(tree)
+		}
+		rpn = tree.RPN()
+	}
+
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	stack, deps, tasks := []float64{}, []int{}, []*Task{}
+	// pending marks the values of the stack computed by user functions, or
+	// from their results, which only agents know. In big precision the
+	// results of all tasks are pending: agents pass them on in full
+	// precision, which float64 values computed here would lose.
+	pending := []bool{}
+	big := precision == "big"
+	seen := make(map[TaskKey]int)
+	// The lookups are counted only once the expression is accepted, so
+	// rejected expressions do not skew the stats.
+	hits, misses, deduplicated := 0, 0, 0
 
 	for _, oper := range rpn {
 		num, err := strconv.ParseFloat(oper, 64)
-		if err != nil {
-			if len(stack) < 2 {
-				log.Printf("it is impossible to create a reverse polish notation for the expression: %s\n", expr)
-				http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+		if err == nil {
+			stack, pending, deps = append(stack, num), append(pending, false), append(deps, 0)
+			continue
+		}
+		arity, digest := 2, ""
+		if isCall(oper) {
+			fn, ok := o.Functions[functionKey(owner, callName(oper))]
+			if !ok {
+				logger.Warn("an unknown function was called in the expression", "expression", expr, "function", callName(oper))
+				http.Error(w, errors.ErrUnknownFunction.Error(), http.StatusUnprocessableEntity)
 				return
 			}
-			if !(oper == "+" || oper == "-" || oper == "*" || oper == "/") {
-				log.Printf("it is impossible to create a reverse polish notation for the expression: %s\n", expr)
-				http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
-				return
+			arity, digest, oper = 1, fn.Digest, callName(oper)
+		} else if _, ok := operations.Lookup(oper); !ok {
+			logger.Warn("it is impossible to create a reverse polish notation for the expression", "expression", expr)
+			http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if len(stack) < arity {
+			logger.Warn("it is impossible to create a reverse polish notation for the expression", "expression", expr)
+			http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		var args [2]float64
+		var argTasks [2]int
+		taskDeps, argsPending := []int{}, false
+		for i := 0; i < arity; i++ {
+			j := len(stack) - arity + i
+			args[i] = stack[j]
+			if deps[j] != 0 {
+				taskDeps = append(taskDeps, deps[j])
+			}
+			if pending[j] {
+				argTasks[i] = deps[j]
+				argsPending = true
 			}
-			i++
-			arg1, arg2 := stack[len(stack)-2], stack[len(stack)-1]
-			stack = stack[:len(stack)-2]
-			switch oper {
-			case "+":
-				stack = append(stack, arg1+arg2)
-			case "-":
-				stack = append(stack, arg1-arg2)
-			case "*":
-				stack = append(stack, arg1*arg2)
-			case "/":
-				if arg2 == 0 {
-					log.Printf("division by zero error for the expression: %s\n", expr)
+		}
+		n := len(stack) - arity
+		stack, pending, deps = stack[:n], pending[:n], deps[:n]
+
+		key := TaskKey{Operation: oper, Function: digest, Arg1: args[0], Arg2: args[1], Precision: precision}
+		value := 0.0
+		if !argsPending {
+			if digest == "" {
+				op, _ := operations.Lookup(oper)
+				if value, err = op.Eval(args[0], args[1]); err != nil {
+					logger.Warn("the operation cannot be computed for the expression", "expression", expr, "operation", oper, "error", err)
 					http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
 					return
 				}
-				stack = append(stack, arg1/arg2)
+				// The value becomes an argument of other tasks or the
+				// result, neither of which can be sent as JSON when it
+				// overflows. Big precision passes results on in full.
+				if !big && !finite(value) {
+					logger.Warn("the operation overflows in the expression", "expression", expr, "operation", oper)
+					http.Error(w, errors.ErrResultInfinite.Error(), http.StatusUnprocessableEntity)
+					return
+				}
 			}
-
-			tasks = append(tasks, &Task{
-				ID:        o.IdTask + i,
-				Arg1:      arg1,
-				Arg2:      arg2,
-				Operation: oper,
-				Status:    "untouched",
-				Result:    0,
-			})
-		} else {
-			stack = append(stack, num)
+			// The cache keeps float64 results, which are exact only in
+			// float precision.
+			if !big {
+				if result, ok := o.Results.Peek(key); ok {
+					hits++
+					stack, pending, deps = append(stack, result), append(pending, false), append(deps, 0)
+					continue
+				}
+				misses++
+			}
+			if id, ok := seen[key]; ok {
+				deduplicated++
+				stack, pending, deps = append(stack, value), append(pending, digest != "" || big), append(deps, id)
+				continue
+			}
+		}
+		id := o.IdTask + len(tasks)
+		if !argsPending {
+			seen[key] = id
 		}
+		tasks = append(tasks, &Task{
+			ID:        id,
+			ExprID:    o.IdExpr,
+			Deps:      taskDeps,
+			Arg1:      args[0],
+			Arg2:      args[1],
+			Operation: oper,
+			Function:  digest,
+			ArgTasks:  argTasks,
+			Precision: precision,
+			Status:    "untouched",
+			Result:    0,
+		})
+		stack, pending, deps = append(stack, value), append(pending, argsPending || digest != "" || big), append(deps, id)
 	}
 	if len(stack) != 1 {
-		log.Printf("it is impossible to create a reverse polish notation for the expression: %s\n", expr)
+		logger.Warn("it is impossible to create a reverse polish notation for the expression", "expression", expr)
 		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
 		return
 	}
+	tasks, endTaskID := o.pruneTasks(tasks, deps[0])
+	if o.Limits.MaxTasks > 0 && len(tasks) > o.Limits.MaxTasks {
+		logger.Warn("an expression exceeds the task limit", "tasks", len(tasks))
+		http.Error(w, errors.ErrTooManyTasks.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := o.checkQuota(owner, len(tasks)); err != nil {
+		logger.Warn("quota exceeded", "error", err)
+		tooManyRequests(w, err, quotaRetryAfter)
+		return
+	}
 
 	expression := &Expression{
-		ID:        o.IdExpr,
-		Status:    "not resolved",
-		Body:      expr,
-		EndTaskID: o.IdTask + i,
+		ID:           o.IdExpr,
+		Status:       "not resolved",
+		Body:         expr,
+		Owner:        owner,
+		Optimized:    tree.String(),
+		Optimization: optimization,
+		Precision:    precision,
+		EndTaskID:    endTaskID,
+		CreatedAt:    time.Now(),
+	}
+	if len(tasks) == 0 {
+		expression.Status = "resolved"
+		expression.Result = stack[0]
+		expression.FinishedAt = expression.CreatedAt
+		o.m.resolved.Inc()
 	}
 	o.Exprs[expression.ID] = expression
 	o.IdExpr++
-	o.IdTask += i + 1
+	o.IdTask += len(tasks)
+	if len(tasks) > 0 {
+		o.addUsage(owner, 1, len(tasks))
+	}
+	o.Results.Record(hits, misses)
+	o.Deduplicated += deduplicated
 
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("expression.id", expression.ID), attribute.Int("expression.tasks", len(tasks)))
 	for _, task := range tasks {
+		task.QueuedAt = expression.CreatedAt
+		_, task.span = o.Tracer.Start(ctx, "Task", trace.WithTimestamp(task.QueuedAt), trace.WithAttributes(
+			attribute.Int("task.id", task.ID),
+			attribute.Int("expression.id", task.ExprID),
+			attribute.String("task.operation", task.Operation),
+		))
 		o.Tasks[task.ID] = task
+		expression.TaskIDs = append(expression.TaskIDs, task.ID)
+		if task.Function != "" {
+			o.retainModule(task.Function)
+		}
 	}
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(models.RespAddExpr{ID: expression.ID}); err != nil {
-		log.Println("server returned an error")
+		logger.Error("server returned an error", "error", err)
 		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
 		return
 	}
-	log.Printf("successful addition of an expression - %s, with id - %d\n", expression.Body, expression.ID)
+	logger.Info("successful addition of an expression", "expression_id", expression.ID, "expression", expression.Body, "tasks", len(tasks))
 }
 
 func (o *Orchestrator) GetExpressions(w http.ResponseWriter, r *http.Request) {
 	o.Mu.Lock()
 	defer o.Mu.Unlock()
 
+	owner := auth.UserFrom(r.Context())
+	logger := logging.FromContext(r.Context()).With("user", owner)
 	var resp []models.RespExpr
 	for _, expr := range o.Exprs {
-		resp = append(resp, models.RespExpr{
-			ID:     expr.ID,
-			Status: expr.Status,
-			Result: expr.Result,
-		})
+		if expr.Owner == owner {
+			resp = append(resp, o.exprResponse(expr))
+		}
 	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].ID < resp[j].ID })
 	if err := json.NewEncoder(w).Encode(map[string][]models.RespExpr{"expressions": resp}); err != nil {
-		log.Println("server returned an error")
+		logger.Error("server returned an error", "error", err)
 		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
 		return
 	}
-	log.Println("expressions were successfully output")
+	logger.Debug("expressions were successfully output", "count", len(resp))
 }
 
 func (o *Orchestrator) GetExpressionByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
+	logger := logging.FromContext(r.Context()).With("user", auth.UserFrom(r.Context()))
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("an incorrect id - %s was requested for the expression\n", idStr)
+		logger.Warn("an incorrect id was requested for the expression", "id", idStr)
 		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
 		return
 	}
@@ -251,79 +673,620 @@ func (o *Orchestrator) GetExpressionByID(w http.ResponseWriter, r *http.Request)
 	defer o.Mu.Unlock()
 
 	expr, ok := o.Exprs[id]
-	if !ok {
-		log.Printf("an expression with an invalid id - %d was requested\n", id)
+	if !ok || expr.Owner != auth.UserFrom(r.Context()) {
+		logger.Warn("an expression with an invalid id was requested", "expression_id", id)
+		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]models.RespExpr{"expression": o.exprResponse(expr)}); err != nil {
+		logger.Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Debug("expression was successfully output", "expression_id", expr.ID)
+}
+
+// GetOptimizedExpression shows the form of the expression that was used to
+// create its tasks.
+func (o *Orchestrator) GetOptimizedExpression(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	logger := logging.FromContext(r.Context()).With("user", auth.UserFrom(r.Context()))
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.Warn("an incorrect id was requested for the expression", "id", idStr)
 		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(map[string]models.RespExpr{"expression": models.RespExpr{
-		ID:     expr.ID,
-		Status: expr.Status,
-		Result: expr.Result,
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	expr, ok := o.Exprs[id]
+	if !ok || expr.Owner != auth.UserFrom(r.Context()) {
+		logger.Warn("an expression with an invalid id was requested", "expression_id", id)
+		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]models.RespOptimized{"expression": {
+		ID:           expr.ID,
+		Body:         expr.Body,
+		Optimized:    expr.Optimized,
+		Optimization: expr.Optimization,
 	}}); err != nil {
-		log.Println("server returned an error")
+		logger.Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Debug("optimized form of the expression was successfully output", "expression_id", expr.ID)
+}
+
+// GetExpressionTasks lists the tasks of the expression in the order they
+// were created.
+func (o *Orchestrator) GetExpressionTasks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	logger := logging.FromContext(r.Context()).With("user", auth.UserFrom(r.Context()))
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.Warn("an incorrect id was requested for the expression", "id", idStr)
+		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	expr, ok := o.Exprs[id]
+	if !ok || expr.Owner != auth.UserFrom(r.Context()) {
+		logger.Warn("an expression with an invalid id was requested", "expression_id", id)
+		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	resp := []models.RespExprTask{}
+	for _, taskID := range expr.TaskIDs {
+		task, ok := o.Tasks[taskID]
+		if !ok {
+			continue
+		}
+		rt := models.RespExprTask{
+			ID:            task.ID,
+			Operation:     task.Operation,
+			Arg1:          task.Arg1,
+			Arg2:          task.Arg2,
+			Deps:          append([]int{}, task.Deps...),
+			Status:        task.Status,
+			Result:        task.Result,
+			Agent:         task.Agent,
+			OperationTime: task.OperationTime,
+			QueuedAt:      task.QueuedAt,
+		}
+		if !task.DispatchedAt.IsZero() {
+			dispatchedAt := task.DispatchedAt
+			rt.DispatchedAt = &dispatchedAt
+		}
+		resp = append(resp, rt)
+	}
+	if err := json.NewEncoder(w).Encode(map[string][]models.RespExprTask{"tasks": resp}); err != nil {
+		logger.Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Debug("tasks of the expression were successfully output", "expression_id", expr.ID)
+}
+
+// CancelExpression stops the computation of the expression. Its tasks that
+// were not handed out are skipped, and results of the leased ones are
+// rejected. Cancelling a cancelled expression does nothing.
+func (o *Orchestrator) CancelExpression(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	logger := logging.FromContext(r.Context()).With("user", auth.UserFrom(r.Context()))
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		logger.Warn("an incorrect id was requested for the expression", "id", idStr)
+		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	expr, ok := o.Exprs[id]
+	if !ok || expr.Owner != auth.UserFrom(r.Context()) {
+		logger.Warn("an expression with an invalid id was requested", "expression_id", id)
+		http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if expr.Status == "resolved" {
+		http.Error(w, errors.ErrExpressionResolved.Error(), http.StatusConflict)
+		return
+	}
+	if expr.Status == "failed" {
+		http.Error(w, errors.ErrExpressionFailed.Error(), http.StatusConflict)
+		return
+	}
+	if expr.Status != "cancelled" {
+		o.finishUsage(expr)
+		expr.Status = "cancelled"
+		expr.FinishedAt = time.Now()
+		o.cancelTasks(expr)
+		logger.Info("the expression was cancelled", "expression_id", expr.ID)
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]models.RespExpr{"expression": o.exprResponse(expr)}); err != nil {
+		logger.Error("server returned an error", "error", err)
 		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
 		return
 	}
-	log.Printf("expression: %s, with id: %d, was successfully output\n", expr.Body, expr.ID)
 }
 
 func (o *Orchestrator) TaskHandler(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	agent, err := o.agentID(r)
+	if err != nil {
+		logger.Warn("an unauthenticated agent was rejected", "remote_addr", r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		o.Mu.Lock()
 		defer o.Mu.Unlock()
-		o.IdTaskSolved++
-		task, ok := o.Tasks[o.IdTaskSolved]
+		now := time.Now()
+		info := o.seeAgent(agent, now)
+		o.seeCapabilities(info, r.Header)
+		if o.room(agent, info, now) == 0 {
+			http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		task, ok := o.nextTask(now, info)
 		if !ok {
-			o.IdTaskSolved--
 			http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
 			return
 		}
-		if err := json.NewEncoder(w).Encode(map[string]models.RespTask{"task": {ID: task.ID, Arg1: task.Arg1, Arg2: task.Arg2, Operation: task.Operation, OperationTime: task.OperationTime}}); err != nil {
-			log.Println("server returned an error")
+		if task.span != nil {
+			tracing.Inject(trace.ContextWithSpan(r.Context(), task.span), w.Header())
+		}
+		resp := o.leaseTask(r.Context(), task, agent, now)
+		if err := json.NewEncoder(w).Encode(map[string]models.RespTask{"task": resp}); err != nil {
+			logger.Error("server returned an error", "error", err)
 			http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
 			return
 		}
-		task.Status = "solved"
 	case http.MethodPost:
 		var req models.ReqTask
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			log.Println("an incorrect issue result structure was sent.")
+			logger.Warn("an incorrect issue result structure was sent", "agent_id", agent, "error", err)
 			http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
 			return
 		}
 		o.Mu.Lock()
 		defer o.Mu.Unlock()
 
-		task, ok := o.Tasks[req.ID]
-		if !ok {
-			log.Printf("the problem with the id - %d was not found to be solved\n", req.ID)
-			http.Error(w, errors.ErrNotFound.Error(), http.StatusNotFound)
+		info := o.seeAgent(agent, time.Now())
+		if code, err := o.acceptResult(r.Context(), agent, info, req); err != nil {
+			http.Error(w, err.Error(), code)
 			return
 		}
-		task.Result = req.Result
-		task.Status = "resolved"
-		task.OperationTime = req.OperationTime
-		o.Tasks[task.ID] = task
-		for _, expr := range o.Exprs {
-			if expr.EndTaskID == task.ID {
-				log.Printf("expression %d was successfully calculated\n", expr.ID)
-				expr.Status = "resolved"
-				expr.Result = task.Result
-				return
+	}
+}
+
+// leaseTask hands the task to the agent until the lease expires and returns
+// it in the form sent to agents. The caller must hold o.Mu.
+func (o *Orchestrator) leaseTask(ctx context.Context, task *Task, agent string, now time.Time) models.RespTask {
+	logger := logging.FromContext(ctx).With("agent_id", agent, "task_id", task.ID, "expression_id", task.ExprID)
+	if task.span != nil {
+		task.span.AddEvent("leased", trace.WithAttributes(attribute.String("agent.id", agent)))
+	}
+	task.Status = "solved"
+	task.Agent = agent
+	task.LeaseExpires = now.Add(o.LeaseTimeout)
+	o.startLease(task)
+	if !task.DispatchedAt.IsZero() {
+		logger.Info("the task was leased again after its lease expired", "operation", task.Operation)
+	} else {
+		logger.Info("the task was leased", "operation", task.Operation)
+		o.m.dispatchLatency.Observe(now.Sub(task.QueuedAt).Seconds())
+	}
+	task.DispatchedAt = now
+	if expr, ok := o.Exprs[task.ExprID]; ok && expr.StartedAt.IsZero() {
+		expr.StartedAt = now
+	}
+	// The arguments computed by other tasks are known by now, since
+	// nextTask hands out only ready tasks.
+	var bigArgs [2]string
+	if dep, ok := o.Tasks[task.ArgTasks[0]]; ok {
+		task.Arg1, bigArgs[0] = dep.Result, dep.BigResult
+	}
+	if dep, ok := o.Tasks[task.ArgTasks[1]]; ok {
+		task.Arg2, bigArgs[1] = dep.Result, dep.BigResult
+	}
+	return models.RespTask{ID: task.ID, ExprID: task.ExprID, Arg1: task.Arg1, Arg2: task.Arg2, BigArg1: bigArgs[0], BigArg2: bigArgs[1], Operation: task.Operation, OperationTime: o.Costs[task.Operation], Precision: task.Precision, Function: task.Function}
+}
+
+// acceptResult stores the result of a task leased to the agent. When the
+// result is rejected it returns the error and the status code to answer with.
+// The caller must hold o.Mu.
+func (o *Orchestrator) acceptResult(ctx context.Context, agent string, info *AgentInfo, req models.ReqTask) (int, error) {
+	logger := logging.FromContext(ctx).With("agent_id", agent, "task_id", req.ID)
+	task, ok := o.Tasks[req.ID]
+	if !ok {
+		logger.Warn("the task was not found to be solved")
+		return http.StatusNotFound, errors.ErrNotFound
+	}
+	logger = logger.With("expression_id", task.ExprID)
+	_, span := o.Tracer.Start(ctx, "AcceptResult", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.Int("task.id", task.ID),
+		attribute.String("agent.id", agent),
+	))
+	defer span.End()
+	if task.Status == "resolved" {
+		span.SetStatus(codes.Error, errors.ErrTaskResolved.Error())
+		logger.Warn("the task has already been solved")
+		return http.StatusConflict, errors.ErrTaskResolved
+	}
+	if task.Status == "cancelled" {
+		logger.Info("a result was sent for a task of a cancelled expression")
+		span.SetStatus(codes.Error, errors.ErrTaskCancelled.Error())
+		return http.StatusConflict, errors.ErrTaskCancelled
+	}
+	if task.Status == "failed" {
+		logger.Warn("a result was sent for a failed task")
+		span.SetStatus(codes.Error, errors.ErrTaskFailed.Error())
+		return http.StatusConflict, errors.ErrTaskFailed
+	}
+	if task.Agent != agent {
+		logger.Warn("a result was sent for a task leased to another agent", "lease_agent_id", task.Agent)
+		span.SetStatus(codes.Error, errors.ErrLeaseMismatch.Error())
+		return http.StatusForbidden, errors.ErrLeaseMismatch
+	}
+	o.endLease(task)
+	if req.Error != "" {
+		span.SetStatus(codes.Error, req.Error)
+		info.Completed++
+		o.failTask(task, req)
+		logger.Warn("the task failed, the expression is stopped", "operation", task.Operation, "error", req.Error)
+		return http.StatusOK, nil
+	}
+	task.Result = req.Result
+	task.Status = "resolved"
+	task.OperationTime = req.OperationTime
+	o.releaseModule(task.Function)
+	if task.Precision == "big" {
+		task.BigResult = req.BigResult
+	} else {
+		o.Results.Set(TaskKey{Operation: task.Operation, Function: task.Function, Arg1: task.Arg1, Arg2: task.Arg2, Precision: task.Precision}, task.Result)
+	}
+	o.Tasks[task.ID] = task
+	now := time.Now()
+	o.m.turnaround.WithLabelValues(task.Operation).Observe(now.Sub(task.DispatchedAt).Seconds())
+	info.Completed++
+	logger.Info("the task was solved", "operation", task.Operation, "operation_time", task.OperationTime)
+	if task.span != nil {
+		task.span.SetAttributes(attribute.String("agent.id", agent), attribute.Float64("task.result", task.Result))
+		task.span.End(trace.WithTimestamp(now))
+		task.span = nil
+	}
+	expr, ok := o.Exprs[task.ExprID]
+	if !ok {
+		return http.StatusOK, nil
+	}
+	o.addUsage(expr.Owner, 0, -1)
+	if expr.EndTaskID == task.ID {
+		logger.Info("the expression was successfully calculated", "result", task.Result)
+		o.finishUsage(expr)
+		expr.Status = "resolved"
+		expr.Result = task.Result
+		expr.FinishedAt = now
+		o.m.resolved.Inc()
+	}
+	return http.StatusOK, nil
+}
+
+// failTask stores the error of the task and fails its expression: the other
+// tasks of the expression are cancelled. The caller must hold o.Mu.
+func (o *Orchestrator) failTask(task *Task, req models.ReqTask) {
+	task.Status = "failed"
+	task.OperationTime = req.OperationTime
+	o.releaseModule(task.Function)
+	if task.span != nil {
+		task.span.SetStatus(codes.Error, req.Error)
+		task.span.End()
+		task.span = nil
+	}
+	expr, ok := o.Exprs[task.ExprID]
+	if !ok {
+		return
+	}
+	o.finishUsage(expr)
+	expr.Status = "failed"
+	expr.Error = req.Error
+	expr.FinishedAt = time.Now()
+	o.cancelTasks(expr)
+}
+
+// cancelTasks cancels the tasks of the expression that are not finished.
+// The caller must hold o.Mu.
+func (o *Orchestrator) cancelTasks(expr *Expression) {
+	for _, taskID := range expr.TaskIDs {
+		task, ok := o.Tasks[taskID]
+		if !ok || task.Status == "resolved" || task.Status == "failed" || task.Status == "cancelled" {
+			continue
+		}
+		o.endLease(task)
+		o.releaseModule(task.Function)
+		task.Status = "cancelled"
+		if task.span != nil {
+			task.span.SetStatus(codes.Error, errors.ErrTaskCancelled.Error())
+			task.span.End()
+			task.span = nil
+		}
+	}
+}
+
+// pruneTasks drops the tasks whose results are not needed to compute the
+// final value, which happens when an enclosing operation was answered from
+// the cache, and renumbers the rest so task ids stay contiguous. It returns
+// the remaining tasks and the id of the task producing the final value, or
+// zero when no task is needed.
+func (o *Orchestrator) pruneTasks(tasks []*Task, endTaskID int) ([]*Task, int) {
+	byID := make(map[int]*Task, len(tasks))
+	for _, task := range tasks {
+		byID[task.ID] = task
+	}
+	needed := make(map[int]bool, len(tasks))
+	queue := []int{endTaskID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		task, ok := byID[id]
+		if !ok || needed[id] {
+			continue
+		}
+		needed[id] = true
+		queue = append(queue, task.Deps...)
+	}
+
+	kept, ids := []*Task{}, make(map[int]int, len(needed))
+	for _, task := range tasks {
+		if needed[task.ID] {
+			ids[task.ID] = o.IdTask + len(kept)
+			kept = append(kept, task)
+		}
+	}
+	for _, task := range kept {
+		task.ID = ids[task.ID]
+		for j, dep := range task.Deps {
+			task.Deps[j] = ids[dep]
+		}
+		for j, dep := range task.ArgTasks {
+			if dep != 0 {
+				task.ArgTasks[j] = ids[dep]
+			}
+		}
+	}
+	return kept, ids[endTaskID]
+}
+
+// GetCacheStats reports the usage of the task result cache and the number of
+// operations shared within expressions instead of being computed twice.
+func (o *Orchestrator) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"cache":        o.Results.Stats(),
+		"deduplicated": o.Deduplicated,
+	}); err != nil {
+		logging.FromContext(r.Context()).Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+	logging.FromContext(r.Context()).Debug("cache statistics were successfully output")
+}
+
+// exprResponse builds the public view of an expression, aggregating the
+// timings and statuses of its tasks. The caller must hold o.Mu.
+func (o *Orchestrator) exprResponse(expr *Expression) models.RespExpr {
+	resp := models.RespExpr{
+		ID:        expr.ID,
+		Status:    expr.Status,
+		Result:    expr.Result,
+		Body:      expr.Body,
+		CreatedAt: expr.CreatedAt,
+		Tasks:     map[string]int{"total": len(expr.TaskIDs)},
+		Precision: expr.Precision,
+		Error:     expr.Error,
+	}
+	if !expr.StartedAt.IsZero() {
+		startedAt := expr.StartedAt
+		resp.StartedAt = &startedAt
+	}
+	if !expr.FinishedAt.IsZero() {
+		finishedAt := expr.FinishedAt
+		resp.FinishedAt = &finishedAt
+	}
+	paths := make(map[int]time.Duration, len(expr.TaskIDs))
+	for _, id := range expr.TaskIDs {
+		task, ok := o.Tasks[id]
+		if !ok {
+			continue
+		}
+		resp.Tasks[task.Status]++
+		if task.Status == "resolved" {
+			resp.ComputeTime += task.OperationTime
+		}
+		if path := o.criticalPath(task, paths); path > resp.CriticalPathTime {
+			resp.CriticalPathTime = path
+		}
+	}
+	return resp
+}
+
+// criticalPath returns the longest chain of resolved operation times ending
+// at the given task. Results are memoized in paths.
+func (o *Orchestrator) criticalPath(task *Task, paths map[int]time.Duration) time.Duration {
+	if path, ok := paths[task.ID]; ok {
+		return path
+	}
+	var longest time.Duration
+	for _, id := range task.Deps {
+		if dep, ok := o.Tasks[id]; ok {
+			if path := o.criticalPath(dep, paths); path > longest {
+				longest = path
 			}
 		}
 	}
+	if task.Status == "resolved" {
+		longest += task.OperationTime
+	}
+	paths[task.ID] = longest
+	return longest
+}
+
+// Router returns the handler serving the public API, together with the
+// internal API unless it has a listener of its own.
+func (o *Orchestrator) Router() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(logging.Middleware, tracing.Middleware, o.instrument)
+	r.HandleFunc("/healthz", o.Healthz).Methods("GET")
+	r.HandleFunc("/readyz", o.Readyz).Methods("GET")
+	o.routePublic(r)
+	if o.InternalPort == "" {
+		o.routeInternal(r)
+	}
+	r.Handle("/", http.RedirectHandler("/ui/", http.StatusFound)).Methods("GET")
+	r.PathPrefix("/ui/").Handler(http.StripPrefix("/ui/", WebUI())).Methods("GET")
+	return r
 }
 
-func (o *Orchestrator) Run() {
+// InternalRouter returns the handler serving the internal API used by agents.
+func (o *Orchestrator) InternalRouter() *mux.Router {
 	r := mux.NewRouter()
-	r.HandleFunc("/api/v1/calculate", o.AddExpression).Methods("POST")
-	r.HandleFunc("/api/v1/expressions", o.GetExpressions).Methods("GET")
-	r.HandleFunc("/api/v1/expressions/{id}", o.GetExpressionByID).Methods("GET")
+	r.Use(logging.Middleware, tracing.Middleware, o.instrument)
+	r.HandleFunc("/healthz", o.Healthz).Methods("GET")
+	r.HandleFunc("/readyz", o.Readyz).Methods("GET")
+	o.routeInternal(r)
+	return r
+}
+
+func (o *Orchestrator) routePublic(r *mux.Router) {
+	r.HandleFunc("/api/v1/register", o.Register).Methods("POST")
+	r.HandleFunc("/api/v1/login", o.Login).Methods("POST")
+
+	// The administrator API is authorized by its own token instead of the
+	// tokens of users, so it is routed before the rest of the api.
+	admin := r.PathPrefix("/api/v1/admin").Subrouter()
+	admin.Use(o.requireAdmin)
+	admin.HandleFunc("/costs", o.GetCosts).Methods("GET")
+	admin.HandleFunc("/costs", o.SetCosts).Methods("PUT")
+
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(o.Auth.Middleware)
+	api.HandleFunc("/calculate", o.AddExpression).Methods("POST")
+	api.HandleFunc("/expressions", o.GetExpressions).Methods("GET")
+	api.HandleFunc("/expressions/{id}", o.GetExpressionByID).Methods("GET")
+	api.HandleFunc("/expressions/{id}/optimized", o.GetOptimizedExpression).Methods("GET")
+	api.HandleFunc("/expressions/{id}/tasks", o.GetExpressionTasks).Methods("GET")
+	api.HandleFunc("/expressions/{id}/cancel", o.CancelExpression).Methods("POST")
+	api.HandleFunc("/cache", o.GetCacheStats).Methods("GET")
+	api.HandleFunc("/status", o.GetStatus).Methods("GET")
+	api.HandleFunc("/functions", o.AddFunction).Methods("POST")
+	api.HandleFunc("/functions", o.GetFunctions).Methods("GET")
+	api.HandleFunc("/functions/{name}", o.DeleteFunction).Methods("DELETE")
+}
+
+func (o *Orchestrator) routeInternal(r *mux.Router) {
 	r.HandleFunc("/internal/task", o.TaskHandler).Methods("GET", "POST")
-	log.Printf("the server is running on the port: %s\n", o.Port)
-	http.ListenAndServe(":"+o.Port, r)
+	r.HandleFunc("/internal/task/{id}", o.ReleaseTask).Methods("DELETE")
+	r.HandleFunc("/internal/tasks", o.TasksHandler).Methods("GET", "POST")
+	r.HandleFunc("/internal/functions/{digest}", o.GetModule).Methods("GET")
+	r.Handle("/metrics", o.requireAdmin(o.MetricsHandler())).Methods("GET")
+}
+
+// Run serves the API until the context is cancelled. Then it stops accepting
+// connections, waits up to ShutdownTimeout for the requests in progress and
+// saves the state.
+func (o *Orchestrator) Run(ctx context.Context) error {
+	if o.TLSCertFile != "" {
+		certs, err := tlsutil.NewReloader(o.TLSCertFile, o.TLSKeyFile, o.TLSClientCA)
+		if err != nil {
+			return err
+		}
+		certs.WatchSIGHUP()
+		o.certs = certs
+	}
+	// Users of the public api do not have client certificates, so they are
+	// only required when agents have a listener of their own.
+	clientAuth := tls.VerifyClientCertIfGiven
+	servers := []*http.Server{}
+	if o.InternalPort != "" {
+		servers = append(servers, o.server(o.InternalPort, o.InternalRouter(), tls.RequireAndVerifyClientCert))
+		clientAuth = tls.NoClientCert
+	}
+	servers = append(servers, o.server(o.Port, o.Router(), clientAuth))
+	if len(o.AgentTokens) == 0 && o.TLSClientCA == "" && !o.AnonymousAgents {
+		slog.Warn("agents are rejected until AGENT_TOKENS or TLS_CLIENT_CA_FILE is set, AGENT_AUTH=none trusts them to name themselves")
+	}
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			slog.Info("the server is running", "addr", srv.Addr)
+			var err error
+			if srv.TLSConfig == nil {
+				err = srv.ListenAndServe()
+			} else {
+				err = srv.ListenAndServeTLS("", "")
+			}
+			if err != http.ErrServerClosed {
+				errs <- err
+			}
+		}()
+	}
+	o.ready.Store(true)
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+		slog.Error("the server stopped", "error", err)
+	}
+	o.ready.Store(false)
+	slog.Info("the server is shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), o.ShutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				slog.Warn("connections were not drained", "addr", srv.Addr, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if o.StateFile != "" {
+		if err := o.SaveState(o.StateFile); err != nil {
+			slog.Error("the state was not saved", "file", o.StateFile, "error", err)
+		} else {
+			slog.Info("the state was saved", "file", o.StateFile)
+		}
+	}
+	return err
+}
+
+func (o *Orchestrator) server(port string, handler http.Handler, clientAuth tls.ClientAuthType) *http.Server {
+	srv := &http.Server{Addr: ":" + port, Handler: handler}
+	if o.certs != nil {
+		srv.TLSConfig = o.certs.ServerConfig(clientAuth)
+	}
+	return srv
 }
@@ -4,24 +4,46 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/config"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
 	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
 	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
+// TestMain keeps the logs of the tested code out of the test output.
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newOrchestrator builds an orchestrator configured by the environment of
+// the test. Agents name themselves, as if AGENT_AUTH=none was set.
+func newOrchestrator(t testing.TB) *orchestrator.Orchestrator {
+	t.Helper()
+	o, err := orchestrator.NewOrchestrator(config.FromEnv())
+	if err != nil {
+		t.Fatalf("failed to create the orchestrator: %v", err)
+	}
+	o.AnonymousAgents = true
+	return o
+}
+
 func TestAddExpression(t *testing.T) {
 	t.Parallel()
 
-	log.SetOutput(io.Discard)
-	defer log.SetOutput(log.Writer())
-
-	o := orchestrator.NewOrchestrator()
+	o := newOrchestrator(t)
 
 	testCases := []struct {
 		name               string
@@ -53,6 +75,11 @@ func TestAddExpression(t *testing.T) {
 			expr:               "2+6-7/0",
 			expectedStatusCode: 422,
 		},
+		{
+			name:               "overflowing operation",
+			expr:               strings.Repeat("9", 200) + "*" + strings.Repeat("9", 200) + "+1",
+			expectedStatusCode: 422,
+		},
 		{
 			name:               "invalid character found",
 			expr:               "2+2+$2",
@@ -97,10 +124,7 @@ func TestAddExpression(t *testing.T) {
 func TestGetExpressions(t *testing.T) {
 	t.Parallel()
 
-	log.SetOutput(io.Discard)
-	defer log.SetOutput(log.Writer())
-
-	o := orchestrator.NewOrchestrator()
+	o := newOrchestrator(t)
 
 	o.Exprs[1] = &orchestrator.Expression{
 		ID:        1,
@@ -131,10 +155,7 @@ func TestGetExpressions(t *testing.T) {
 func TestGetExpressionByID(t *testing.T) {
 	t.Parallel()
 
-	log.SetOutput(io.Discard)
-	defer log.SetOutput(log.Writer())
-
-	o := orchestrator.NewOrchestrator()
+	o := newOrchestrator(t)
 	o.Exprs[1] = &orchestrator.Expression{ID: 1, Status: "not resolved", Body: "2 + 2"}
 
 	testCases := []struct {
@@ -168,10 +189,7 @@ func TestGetExpressionByID(t *testing.T) {
 func TestTaskHandler(t *testing.T) {
 	t.Parallel()
 
-	log.SetOutput(io.Discard)
-	defer log.SetOutput(log.Writer())
-
-	o := orchestrator.NewOrchestrator()
+	o := newOrchestrator(t)
 	o.Tasks[1] = &orchestrator.Task{ID: 1, Arg1: 2, Arg2: 2, Operation: "+", Status: "untouched"}
 
 	testCasesGet := []struct {
@@ -261,3 +279,297 @@ func TestTaskHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestExpressionTimings(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.Exprs[1] = &orchestrator.Expression{
+		ID:        1,
+		Status:    "not resolved",
+		Body:      "(1+2)*(3+4)",
+		EndTaskID: 3,
+		TaskIDs:   []int{1, 2, 3},
+		CreatedAt: time.Now(),
+	}
+	o.Tasks[1] = &orchestrator.Task{ID: 1, ExprID: 1, Operation: "+", Status: "resolved", OperationTime: 2 * time.Second}
+	o.Tasks[2] = &orchestrator.Task{ID: 2, ExprID: 1, Operation: "+", Status: "resolved", OperationTime: 3 * time.Second}
+	o.Tasks[3] = &orchestrator.Task{ID: 3, ExprID: 1, Deps: []int{1, 2}, Operation: "*", Status: "untouched"}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/1", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+
+	o.GetExpressionByID(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+
+	var resp struct {
+		Expression models.RespExpr `json:"expression"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	expr := resp.Expression
+	if expr.Body != "(1+2)*(3+4)" {
+		t.Errorf("invalid body: got %v want %v", expr.Body, "(1+2)*(3+4)")
+	}
+	if expr.ComputeTime != 5*time.Second {
+		t.Errorf("invalid compute time: got %v want %v", expr.ComputeTime, 5*time.Second)
+	}
+	if expr.CriticalPathTime != 3*time.Second {
+		t.Errorf("invalid critical path time: got %v want %v", expr.CriticalPathTime, 3*time.Second)
+	}
+	if expr.Tasks["total"] != 3 || expr.Tasks["resolved"] != 2 || expr.Tasks["untouched"] != 1 {
+		t.Errorf("invalid task counts: %v", expr.Tasks)
+	}
+	if expr.StartedAt != nil || expr.FinishedAt != nil {
+		t.Errorf("unexpected timestamps: started %v finished %v", expr.StartedAt, expr.FinishedAt)
+	}
+}
+
+func TestResultCaching(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+
+	add := func(expr string) int {
+		reqBody, _ := json.Marshal(models.ReqAddExpr{Expression: expr})
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(reqBody))
+		w := httptest.NewRecorder()
+		o.AddExpression(w, r)
+		var resp models.RespAddExpr
+		json.NewDecoder(w.Result().Body).Decode(&resp)
+		return resp.ID
+	}
+
+	id := add("(1+2)*(1+2)")
+	if got := len(o.Exprs[id].TaskIDs); got != 2 {
+		t.Fatalf("common subexpression was not shared: got %d tasks want 2", got)
+	}
+	if o.Deduplicated != 1 {
+		t.Fatalf("invalid deduplication count: got %d want 1", o.Deduplicated)
+	}
+
+	for _, taskID := range o.Exprs[id].TaskIDs {
+		task := o.Tasks[taskID]
+		result := task.Arg1 + task.Arg2
+		if task.Operation == "*" {
+			result = task.Arg1 * task.Arg2
+		}
+		jsonBytes, _ := json.Marshal(models.ReqTask{ID: task.ID, Result: result, OperationTime: time.Millisecond})
+		r := httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(jsonBytes))
+		o.TaskHandler(httptest.NewRecorder(), r)
+	}
+
+	id = add("(1+2)*(1+2)")
+	if expr := o.Exprs[id]; expr.Status != "resolved" || expr.Result != 9 || len(expr.TaskIDs) != 0 {
+		t.Fatalf("cached expression must be resolved without tasks: got status %v result %v tasks %d", expr.Status, expr.Result, len(expr.TaskIDs))
+	}
+
+	id = add("(1+2)*(1+2)+1")
+	expr := o.Exprs[id]
+	if len(expr.TaskIDs) != 1 {
+		t.Fatalf("cached operations were computed again: got %d tasks want 1", len(expr.TaskIDs))
+	}
+	if task := o.Tasks[expr.EndTaskID]; task.Arg1 != 9 || task.Arg2 != 1 || len(task.Deps) != 0 {
+		t.Fatalf("invalid task: %+v", task)
+	}
+
+	stats := o.Results.Stats()
+	if stats.Hits == 0 || stats.Misses == 0 {
+		t.Fatalf("cache statistics were not collected: %+v", stats)
+	}
+
+	// A rejected expression leaves the statistics unchanged.
+	o.Limits.MaxTasks = 1
+	if id := add("(1+2)*(1+2)+(3+4)*(3+4)"); id != 0 {
+		t.Fatalf("an expression over the task limit was accepted: %d", id)
+	}
+	if got := o.Results.Stats(); got.Hits != stats.Hits || got.Misses != stats.Misses || o.Deduplicated != 1 {
+		t.Errorf("a rejected expression was counted: got %+v, %d deduplicated want %+v, 1", got, o.Deduplicated, stats)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	router := o.Router()
+
+	req := httptest.NewRequest(http.MethodGet, "/internal/task", nil)
+	req.Header.Set(logging.RequestIDHeader, "agent-request")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if got := w.Header().Get(logging.RequestIDHeader); got != "agent-request" {
+		t.Errorf("invalid request id: got %q want %q", got, "agent-request")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil))
+	if got := w.Header().Get(logging.RequestIDHeader); got == "" {
+		t.Error("a request id was not generated")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	o := newOrchestrator(t)
+	o.Tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	router := o.Router()
+
+	if resp := submit(o, "", "2+3"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("invalid status code: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, agentRequest(http.MethodGet, "", nil))
+	traceparent := w.Header().Get("traceparent")
+	if len(traceparent) != 55 {
+		t.Fatalf("the task was sent without a trace context: %q", traceparent)
+	}
+
+	// The agent continues the trace with a span of its own.
+	agentSpan := "00f067aa0ba902b7"
+	req := agentRequest(http.MethodPost, "", models.ReqTask{ID: 1, Result: 5})
+	req.Header.Set("traceparent", traceparent[:36]+agentSpan+"-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"AddExpression", "Task", "AcceptResult"} {
+		if spans[name] == nil {
+			t.Fatalf("span %s was not recorded", name)
+		}
+		if got := spans[name].SpanContext().TraceID().String(); got != traceparent[3:35] {
+			t.Errorf("span %s belongs to another trace: %s", name, got)
+		}
+	}
+	if spans["Task"].Parent().SpanID() != spans["AddExpression"].SpanContext().SpanID() {
+		t.Error("the task span is not a child of the expression span")
+	}
+	if got := spans["Task"].SpanContext().SpanID().String(); got != traceparent[36:52] {
+		t.Errorf("the task was sent with the context of another span: %s", got)
+	}
+	if got := spans["AcceptResult"].Parent().SpanID().String(); got != agentSpan {
+		t.Errorf("the result span is not a child of the agent span: %s", got)
+	}
+}
+
+func TestCancelExpression(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	submit(o, "alice", "(1+2)*(3+4)")
+	submit(o, "alice", "5+6")
+	submit(o, "alice", "7")
+
+	cancel := func(user, id string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/expressions/"+id+"/cancel", nil)
+		r = mux.SetURLVars(r, map[string]string{"id": id})
+		r = r.WithContext(auth.WithUser(r.Context(), user))
+		w := httptest.NewRecorder()
+		o.CancelExpression(w, r)
+		return w.Code
+	}
+
+	// The first task of the expression is already leased when it is cancelled.
+	w := httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodGet, "", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status code: got %v want %v", w.Code, http.StatusOK)
+	}
+
+	testCases := []struct {
+		name               string
+		user               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"another user", "bob", "1", http.StatusNotFound},
+		{"invalid id", "alice", "x", http.StatusNotFound},
+		{"resolved expression", "alice", "3", http.StatusConflict},
+		{"unresolved expression", "alice", "1", http.StatusOK},
+		{"cancelled expression", "alice", "1", http.StatusOK},
+	}
+	for _, ts := range testCases {
+		if code := cancel(ts.user, ts.id); code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, code, ts.expectedStatusCode)
+		}
+	}
+	if status := o.Exprs[1].Status; status != "cancelled" {
+		t.Fatalf("invalid status: got %q want %q", status, "cancelled")
+	}
+
+	w = httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodPost, "", models.ReqTask{ID: 1, Result: 3}))
+	if w.Code != http.StatusConflict {
+		t.Errorf("result of a cancelled task: got %v want %v", w.Code, http.StatusConflict)
+	}
+	w = httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodGet, "", nil))
+	var resp map[string]models.RespTask
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["task"].ExprID != 2 {
+		t.Errorf("tasks of the cancelled expression were handed out: got task %+v", resp["task"])
+	}
+}
+
+func TestGetExpressionTasks(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	submit(o, "alice", "2+2*3")
+	w := httptest.NewRecorder()
+	o.TaskHandler(w, agentRequest(http.MethodGet, "", nil))
+
+	testCases := []struct {
+		name               string
+		user               string
+		id                 string
+		expectedStatusCode int
+		expectedTasks      []models.RespExprTask
+	}{
+		{"another user", "bob", "1", http.StatusNotFound, nil},
+		{"unknown expression", "alice", "2", http.StatusNotFound, nil},
+		{"owner", "alice", "1", http.StatusOK, []models.RespExprTask{
+			{ID: 1, Operation: "*", Arg1: 2, Arg2: 3, Deps: []int{}, Status: "solved"},
+			{ID: 2, Operation: "+", Arg1: 2, Arg2: 6, Deps: []int{1}, Status: "untouched"},
+		}},
+	}
+	for _, ts := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+ts.id+"/tasks", nil)
+		r = mux.SetURLVars(r, map[string]string{"id": ts.id})
+		r = r.WithContext(auth.WithUser(r.Context(), ts.user))
+		w := httptest.NewRecorder()
+		o.GetExpressionTasks(w, r)
+		if w.Code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, w.Code, ts.expectedStatusCode)
+			continue
+		}
+		if ts.expectedTasks == nil {
+			continue
+		}
+		var resp map[string][]models.RespExprTask
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", ts.name, err)
+		}
+		tasks := resp["tasks"]
+		if len(tasks) != len(ts.expectedTasks) {
+			t.Fatalf("%s: got %d tasks want %d", ts.name, len(tasks), len(ts.expectedTasks))
+		}
+		for i, want := range ts.expectedTasks {
+			got := tasks[i]
+			if got.ID != want.ID || got.Operation != want.Operation || got.Arg1 != want.Arg1 || got.Arg2 != want.Arg2 ||
+				got.Status != want.Status || len(got.Deps) != len(want.Deps) {
+				t.Errorf("%s: invalid task %d: got %+v want %+v", ts.name, i, got, want)
+			}
+		}
+		if tasks[0].DispatchedAt == nil || tasks[1].DispatchedAt != nil {
+			t.Errorf("%s: invalid dispatch times", ts.name)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package orchestrator
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+)
+
+// quotaRetryAfter is suggested to clients over quota, since their unresolved
+// expressions are usually resolved within seconds.
+const quotaRetryAfter = time.Second
+
+// limitKey identifies the client for rate limiting: the user when the request
+// is authenticated, the IP address otherwise.
+func limitKey(r *http.Request) string {
+	if login := auth.UserFrom(r.Context()); login != "" {
+		return "user:" + login
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// usage counts the unresolved expressions of an owner and their tasks that
+// are not resolved yet.
+type usage struct {
+	expressions int
+	tasks       int
+}
+
+// checkQuota verifies that the owner may add an expression made of newTasks
+// tasks. The caller must hold o.Mu.
+func (o *Orchestrator) checkQuota(owner string, newTasks int) error {
+	u := o.usage[owner]
+	if o.MaxUnresolved > 0 && u.expressions >= o.MaxUnresolved {
+		return errors.ErrExpressionQuota
+	}
+	if o.MaxTasks > 0 && u.tasks+newTasks > o.MaxTasks {
+		return errors.ErrTaskQuota
+	}
+	return nil
+}
+
+// addUsage changes the counts of the owner by the given amounts. The caller
+// must hold o.Mu.
+func (o *Orchestrator) addUsage(owner string, expressions, tasks int) {
+	u := o.usage[owner]
+	u.expressions += expressions
+	u.tasks += tasks
+	if u == (usage{}) {
+		delete(o.usage, owner)
+		return
+	}
+	o.usage[owner] = u
+}
+
+// finishUsage stops counting the expression, which was resolved, cancelled
+// or has failed, and its tasks that were not resolved. The caller must hold
+// o.Mu.
+func (o *Orchestrator) finishUsage(expr *Expression) {
+	tasks := 0
+	for _, id := range expr.TaskIDs {
+		if task, ok := o.Tasks[id]; ok && task.Status != "resolved" {
+			tasks++
+		}
+	}
+	o.addUsage(expr.Owner, -1, -tasks)
+}
+
+// indexUsage counts the unresolved expressions and tasks of every owner after
+// the state is loaded. The caller must hold o.Mu.
+func (o *Orchestrator) indexUsage() {
+	o.usage = make(map[string]usage)
+	for _, expr := range o.Exprs {
+		if expr.Status != "not resolved" {
+			continue
+		}
+		tasks := 0
+		for _, id := range expr.TaskIDs {
+			if task, ok := o.Tasks[id]; ok && task.Status != "resolved" {
+				tasks++
+			}
+		}
+		o.addUsage(expr.Owner, 1, tasks)
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, err error, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
@@ -0,0 +1,163 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/ratelimit"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+func submit(o *orchestrator.Orchestrator, user, expr string) *http.Response {
+	reqBody, _ := json.Marshal(models.ReqAddExpr{Expression: expr})
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(reqBody))
+	r = r.WithContext(auth.WithUser(r.Context(), user))
+	w := httptest.NewRecorder()
+	o.AddExpression(w, r)
+	return w.Result()
+}
+
+func TestAddExpressionRateLimit(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.RateLimiter = ratelimit.New(0.001, 2)
+
+	for i := 0; i < 2; i++ {
+		if res := submit(o, "alice", "2+2"); res.StatusCode != http.StatusCreated {
+			t.Fatalf("request within the burst: got %v want %v", res.StatusCode, http.StatusCreated)
+		}
+	}
+	res := submit(o, "alice", "2+2")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: got %v want %v", res.StatusCode, http.StatusTooManyRequests)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Fatalf("Retry-After header is missing")
+	}
+	if res := submit(o, "bob", "2+2"); res.StatusCode != http.StatusCreated {
+		t.Fatalf("request of another user: got %v want %v", res.StatusCode, http.StatusCreated)
+	}
+}
+
+func TestAddExpressionQuota(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		maxUnresolved int
+		maxTasks      int
+		exprs         []string
+		expectedCodes []int
+	}{
+		{
+			name:          "unresolved expressions",
+			maxUnresolved: 2,
+			exprs:         []string{"1+2", "3+4", "5+6"},
+			expectedCodes: []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests},
+		},
+		{
+			name:          "unresolved tasks",
+			maxTasks:      3,
+			exprs:         []string{"1+2+3", "4*5+6", "7-8"},
+			expectedCodes: []int{http.StatusCreated, http.StatusTooManyRequests, http.StatusCreated},
+		},
+	}
+	for _, ts := range testCases {
+		ts := ts
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+
+			o := newOrchestrator(t)
+			o.MaxUnresolved = ts.maxUnresolved
+			o.MaxTasks = ts.maxTasks
+
+			for i, expr := range ts.exprs {
+				res := submit(o, "alice", expr)
+				if res.StatusCode != ts.expectedCodes[i] {
+					t.Fatalf("%s: invalid status code: got %v want %v", expr, res.StatusCode, ts.expectedCodes[i])
+				}
+			}
+			if res := submit(o, "bob", ts.exprs[0]); res.StatusCode != http.StatusCreated {
+				t.Fatalf("quota of another user: got %v want %v", res.StatusCode, http.StatusCreated)
+			}
+		})
+	}
+}
+
+func TestQuotaRelease(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.MaxUnresolved = 1
+	o.MaxTasks = 2
+
+	lease := func() models.RespTask {
+		w := httptest.NewRecorder()
+		o.TaskHandler(w, agentRequest(http.MethodGet, "", nil))
+		var resp map[string]models.RespTask
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp["task"]
+	}
+	post := func(req models.ReqTask) {
+		w := httptest.NewRecorder()
+		o.TaskHandler(w, agentRequest(http.MethodPost, "", req))
+		if w.Code != http.StatusOK {
+			t.Fatalf("the result was rejected: %v %s", w.Code, w.Body)
+		}
+	}
+	cancel := func(id int) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/expressions/"+strconv.Itoa(id)+"/cancel", nil)
+		r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(id)})
+		r = r.WithContext(auth.WithUser(r.Context(), "alice"))
+		w := httptest.NewRecorder()
+		o.CancelExpression(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("the expression was not cancelled: %v", w.Code)
+		}
+	}
+	// Each way an expression ends frees the quota for the next one.
+	steps := []struct {
+		name   string
+		expr   string
+		finish func(id int)
+	}{
+		{"resolved", "1+2+3", func(int) {
+			task := lease()
+			post(models.ReqTask{ID: task.ID, Result: 3})
+			// A resolved task frees its place before the expression ends.
+			if res := submit(o, "alice", "9-1"); res.StatusCode != http.StatusTooManyRequests {
+				t.Fatalf("over the quota of expressions: got %v want %v", res.StatusCode, http.StatusTooManyRequests)
+			}
+			task = lease()
+			post(models.ReqTask{ID: task.ID, Result: 6})
+		}},
+		{"cancelled", "4*5", cancel},
+		{"failed", "6/7", func(int) {
+			task := lease()
+			post(models.ReqTask{ID: task.ID, Error: "the agent failed"})
+		}},
+	}
+	for _, step := range steps {
+		res := submit(o, "alice", step.expr)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("%s: the quota was not freed: got %v want %v", step.name, res.StatusCode, http.StatusCreated)
+		}
+		var resp models.RespAddExpr
+		json.NewDecoder(res.Body).Decode(&resp)
+		if res := submit(o, "alice", "8+9"); res.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("%s: over the quota: got %v want %v", step.name, res.StatusCode, http.StatusTooManyRequests)
+		}
+		step.finish(resp.ID)
+	}
+	if res := submit(o, "alice", "8+9"); res.StatusCode != http.StatusCreated {
+		t.Fatalf("the quota was not freed: got %v want %v", res.StatusCode, http.StatusCreated)
+	}
+}
@@ -0,0 +1,91 @@
+package orchestrator_test
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/operations"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+// Power is registered only for the tests, to check that an operator added
+// to the registry is parsed, printed and computed consistently.
+func init() {
+	operations.MustRegister(operations.Operation{
+		Symbol: "^", Arity: 2, Precedence: 3, Associativity: operations.Right,
+		Eval: func(a, b float64) (float64, error) { return math.Pow(a, b), nil },
+	})
+}
+
+func TestRegisteredOperation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		expr           string
+		expectedRPN    string
+		expectedString string
+		expectedResult float64
+	}{
+		{"right associative", "2^3^2", "2 3 2 ^ ^", "2^3^2", 512},
+		{"grouped to the left", "(2^3)^2", "2 3 ^ 2 ^", "(2^3)^2", 64},
+		{"binds tighter than multiplication", "2*3^2", "2 3 2 ^ *", "2*3^2", 18},
+		{"operand in brackets", "(1+1)^3-1", "1 1 + 3 ^ 1 -", "(1+1)^3-1", 7},
+	}
+	for _, ts := range testCases {
+		rpn, err := orchestrator.ToPolishNotation(ts.expr)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", ts.name, err)
+			continue
+		}
+		if got := strings.Join(rpn, " "); got != ts.expectedRPN {
+			t.Errorf("%s: invalid notation: got %q want %q", ts.name, got, ts.expectedRPN)
+		}
+		tree, err := orchestrator.BuildTree(rpn)
+		if err != nil {
+			t.Errorf("%s: failed to build the tree: %v", ts.name, err)
+			continue
+		}
+		if got := tree.String(); got != ts.expectedString {
+			t.Errorf("%s: invalid expression: got %q want %q", ts.name, got, ts.expectedString)
+		}
+
+		// The tasks are computed the way agents compute them.
+		o := newOrchestrator(t)
+		o.Optimization = false
+		res := submit(o, "alice", ts.expr)
+		if res.StatusCode != http.StatusCreated {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, res.StatusCode, http.StatusCreated)
+			continue
+		}
+		var added models.RespAddExpr
+		json.NewDecoder(res.Body).Decode(&added)
+		for {
+			w := httptest.NewRecorder()
+			o.TaskHandler(w, agentRequest(http.MethodGet, "", nil))
+			if w.Code != http.StatusOK {
+				break
+			}
+			var resp map[string]models.RespTask
+			json.NewDecoder(w.Body).Decode(&resp)
+			task := resp["task"]
+			result, err := operations.Evaluate(task.Operation, task.Arg1, task.Arg2)
+			if err != nil {
+				t.Fatalf("%s: failed to compute the task: %v", ts.name, err)
+			}
+			w = httptest.NewRecorder()
+			o.TaskHandler(w, agentRequest(http.MethodPost, "", models.ReqTask{ID: task.ID, Result: result}))
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: the result was not accepted: %v", ts.name, w.Code)
+			}
+		}
+		if expr := o.Exprs[added.ID]; expr.Status != "resolved" || expr.Result != ts.expectedResult {
+			t.Errorf("%s: invalid result: got %v %v want %v", ts.name, expr.Status, expr.Result, ts.expectedResult)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package orchestrator
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// precisions are the precision modes an expression can be computed in.
+// Tasks without a mode are computed in float.
+var precisions = map[string]bool{"float": true, "big": true}
+
+// seeCapabilities records the capabilities the agent advertises with its
+// request for tasks. An agent that does not advertise them gets every float
+// task and has no capacity limit, like agents that predate the headers. The
+// caller must hold o.Mu.
+func (o *Orchestrator) seeCapabilities(info *AgentInfo, header http.Header) {
+	info.Operations = parseCapabilities(header.Get("X-Agent-Operations"))
+	info.Precisions = parseCapabilities(header.Get("X-Agent-Precision"))
+	info.Capacity, _ = strconv.Atoi(header.Get("X-Agent-Capacity"))
+	info.Capacity = max(info.Capacity, 0)
+	info.Functions = header.Get("X-Agent-Functions") == "true"
+	info.OperationTimes = parseOperationTimes(header.Get("X-Agent-Operation-Times"))
+}
+
+// parseOperationTimes reads comma-separated operation=milliseconds pairs,
+// skipping malformed ones.
+func parseOperationTimes(s string) map[string]time.Duration {
+	var times map[string]time.Duration
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.LastIndex(pair, "=")
+		if i < 1 {
+			continue
+		}
+		ms, err := strconv.ParseInt(strings.TrimSpace(pair[i+1:]), 10, 64)
+		if err != nil || ms < 0 || ms > math.MaxInt64/int64(time.Millisecond) {
+			continue
+		}
+		if times == nil {
+			times = make(map[string]time.Duration)
+		}
+		times[strings.TrimSpace(pair[:i])] = time.Duration(ms) * time.Millisecond
+	}
+	return times
+}
+
+func parseCapabilities(s string) map[string]bool {
+	set := parseList(s)
+	if len(set) == 0 {
+		return nil
+	}
+	return set
+}
+
+// accepts reports whether the agent can compute the task. Tasks of user
+// functions go only to agents running them.
+func (info *AgentInfo) accepts(task *Task) bool {
+	if task.Function != "" {
+		if !info.Functions {
+			return false
+		}
+	} else if info.Operations != nil && !info.Operations[task.Operation] {
+		return false
+	}
+	precision := task.Precision
+	if precision == "" {
+		precision = "float"
+	}
+	if info.Precisions == nil {
+		return precision == "float"
+	}
+	return info.Precisions[precision]
+}
+
+// argsKnown reports whether the results the arguments of the task are taken from
+// are known. The caller must hold o.Mu.
+func (o *Orchestrator) argsKnown(task *Task) bool {
+	for _, id := range task.ArgTasks {
+		if dep, ok := o.Tasks[id]; ok && dep.Status != "resolved" {
+			return false
+		}
+	}
+	return true
+}
+
+// room returns how many more tasks the agent may lease, or -1 when its
+// capacity is not limited. The caller must hold o.Mu.
+func (o *Orchestrator) room(agent string, info *AgentInfo, now time.Time) int {
+	if info.Capacity == 0 {
+		return -1
+	}
+	o.expireLeases(now)
+	return max(info.Capacity-o.held[agent], 0)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if set == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,156 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+func TestCapabilityRouting(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.Tasks[1] = &orchestrator.Task{ID: 1, Arg1: 1, Arg2: 2, Operation: "*", Status: "untouched"}
+	o.Tasks[2] = &orchestrator.Task{ID: 2, Arg1: 1, Arg2: 2, Operation: "+", Precision: "big", Status: "untouched"}
+	o.Tasks[3] = &orchestrator.Task{ID: 3, Arg1: 1, Arg2: 2, Operation: "+", Status: "untouched"}
+	router := o.Router()
+
+	fetch := func(agent string, header map[string]string) int {
+		r := httptest.NewRequest(http.MethodGet, "/internal/task", nil)
+		r.Header.Set("X-Agent-ID", agent)
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			return 0
+		}
+		var resp map[string]models.RespTask
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp["task"].ID
+	}
+
+	testCases := []struct {
+		name       string
+		agent      string
+		header     map[string]string
+		expectedID int
+	}{
+		{"addition only skips multiplication", "adder", map[string]string{"X-Agent-Operations": "+, -"}, 3},
+		{"no float addition left", "adder", map[string]string{"X-Agent-Operations": "+,-"}, 0},
+		{"big precision", "big", map[string]string{"X-Agent-Precision": "big"}, 2},
+		{"no capabilities gets float tasks", "legacy", nil, 1},
+	}
+	for _, ts := range testCases {
+		if id := fetch(ts.agent, ts.header); id != ts.expectedID {
+			t.Errorf("%s: invalid task: got %v want %v", ts.name, id, ts.expectedID)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	o.GetStatus(w, httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+	var status models.RespStatus
+	json.NewDecoder(w.Body).Decode(&status)
+	if len(status.Agents) != 3 {
+		t.Fatalf("invalid agents: %+v", status.Agents)
+	}
+	if adder := status.Agents[0]; adder.ID != "adder" || len(adder.Operations) != 2 || adder.Operations[0] != "+" || adder.Precisions != nil {
+		t.Errorf("invalid capabilities of the agent: %+v", adder)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	for id := 1; id <= 4; id++ {
+		o.Tasks[id] = &orchestrator.Task{ID: id, Arg1: 1, Arg2: 2, Operation: "+", Status: "untouched"}
+	}
+	router := o.Router()
+	call := func(method, target string, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			jsonBytes, _ := json.Marshal(body)
+			reader = bytes.NewReader(jsonBytes)
+		}
+		r := httptest.NewRequest(method, target, reader)
+		r.Header.Set("X-Agent-ID", "agent-1")
+		r.Header.Set("X-Agent-Capacity", "2")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call(http.MethodGet, "/internal/tasks?limit=5", nil)
+	var tasks models.RespTasks
+	json.NewDecoder(w.Body).Decode(&tasks)
+	if len(tasks.Tasks) != 2 {
+		t.Fatalf("invalid number of tasks within the capacity: got %v want %v", len(tasks.Tasks), 2)
+	}
+	if w := call(http.MethodGet, "/internal/task", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("a task beyond the capacity was handed out: got %v want %v", w.Code, http.StatusNotFound)
+	}
+	if w := call(http.MethodPost, "/internal/task", models.ReqTask{ID: tasks.Tasks[0].ID, Result: 3}); w.Code != http.StatusOK {
+		t.Fatalf("invalid status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	if w := call(http.MethodGet, "/internal/task", nil); w.Code != http.StatusOK {
+		t.Fatalf("a task within the capacity was not handed out: got %v want %v", w.Code, http.StatusOK)
+	}
+
+	// A released task frees the capacity and is the first one handed out
+	// again.
+	released := tasks.Tasks[1].ID
+	if w := call(http.MethodDelete, "/internal/task/"+strconv.Itoa(released), nil); w.Code != http.StatusOK {
+		t.Fatalf("invalid status code of the release: got %v want %v", w.Code, http.StatusOK)
+	}
+	w = call(http.MethodGet, "/internal/task", nil)
+	var resp map[string]models.RespTask
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp["task"].ID != released {
+		t.Fatalf("the released task was not handed out again: got %v %+v", w.Code, resp["task"])
+	}
+}
+
+func TestAddExpressionPrecision(t *testing.T) {
+	t.Parallel()
+
+	o := newOrchestrator(t)
+	o.Optimization = false
+	testCases := []struct {
+		name               string
+		precision          string
+		expectedStatusCode int
+		expectedPrecision  string
+	}{
+		{"default", "", http.StatusCreated, ""},
+		{"float", "float", http.StatusCreated, ""},
+		{"big", "big", http.StatusCreated, "big"},
+		{"unknown", "decimal", http.StatusUnprocessableEntity, ""},
+	}
+	for _, ts := range testCases {
+		body, _ := json.Marshal(models.ReqAddExpr{Expression: "2+3", Precision: ts.precision})
+		w := httptest.NewRecorder()
+		o.AddExpression(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewReader(body)))
+		if w.Code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, w.Code, ts.expectedStatusCode)
+			continue
+		}
+		if w.Code != http.StatusCreated {
+			continue
+		}
+		var resp models.RespAddExpr
+		json.NewDecoder(w.Body).Decode(&resp)
+		expr := o.Exprs[resp.ID]
+		if expr.Precision != ts.expectedPrecision || len(expr.TaskIDs) != 1 || o.Tasks[expr.TaskIDs[0]].Precision != ts.expectedPrecision {
+			t.Errorf("%s: invalid precision of the expression: %+v", ts.name, expr)
+		}
+	}
+}
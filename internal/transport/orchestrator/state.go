@@ -0,0 +1,100 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// state is the part of the orchestrator that survives a restart.
+type state struct {
+	Exprs        map[int]*Expression `json:"expressions"`
+	Tasks        map[int]*Task       `json:"tasks"`
+	Users        map[string]*User    `json:"users"`
+	IdExpr       int                 `json:"id_expr"`
+	IdTask       int                 `json:"id_task"`
+	IdTaskSolved int                 `json:"id_task_solved"`
+	Deduplicated int                 `json:"deduplicated"`
+	// Modules are kept by digest while functions or unfinished tasks use
+	// them.
+	Functions map[string]*Function `json:"functions"`
+	Modules   map[string][]byte    `json:"modules"`
+}
+
+// SaveState writes the expressions, tasks and users to the file. The file is
+// replaced atomically, so a crash while saving keeps the previous state.
+func (o *Orchestrator) SaveState(path string) error {
+	o.Mu.Lock()
+	data, err := json.Marshal(state{
+		Exprs:        o.Exprs,
+		Tasks:        o.Tasks,
+		Users:        o.Users,
+		Functions:    o.Functions,
+		Modules:      o.Modules,
+		IdExpr:       o.IdExpr,
+		IdTask:       o.IdTask,
+		IdTaskSolved: o.IdTaskSolved,
+		Deduplicated: o.Deduplicated,
+	})
+	o.Mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadState replaces the expressions, tasks and users with the ones saved in
+// the file. Tasks leased before the restart stay leased to the same agents.
+func (o *Orchestrator) LoadState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+	if s.Exprs != nil {
+		o.Exprs = s.Exprs
+	}
+	if s.Tasks != nil {
+		o.Tasks = s.Tasks
+	}
+	if s.Users != nil {
+		o.Users = s.Users
+	}
+	if s.Functions != nil {
+		o.Functions = s.Functions
+	}
+	if s.Modules != nil {
+		o.Modules = s.Modules
+	}
+	o.IdExpr = max(s.IdExpr, 1)
+	o.IdTask = max(s.IdTask, 1)
+	o.IdTaskSolved = s.IdTaskSolved
+	o.indexLeases()
+	o.indexModules()
+	o.indexUsage()
+	o.Deduplicated = s.Deduplicated
+	return nil
+}
@@ -0,0 +1,52 @@
+package orchestrator_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/transport/orchestrator"
+)
+
+func TestState(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	o := newOrchestrator(t)
+	o.Users["alice"] = &orchestrator.User{Login: "alice", PasswordHash: []byte("hash")}
+	for _, expr := range []string{"2+2*2", "7"} {
+		if resp := submit(o, "alice", expr); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("invalid status code: got %v want %v", resp.StatusCode, http.StatusCreated)
+		}
+	}
+	if err := o.SaveState(path); err != nil {
+		t.Fatalf("failed to save state: %v", err)
+	}
+
+	restored := newOrchestrator(t)
+	if err := restored.LoadState(path); err != nil {
+		t.Fatalf("failed to load state: %v", err)
+	}
+	if len(restored.Exprs) != 2 || len(restored.Tasks) != 2 || restored.Users["alice"] == nil {
+		t.Fatalf("invalid state: %d expressions, %d tasks, users %v", len(restored.Exprs), len(restored.Tasks), restored.Users)
+	}
+	if restored.IdExpr != o.IdExpr || restored.IdTask != o.IdTask {
+		t.Errorf("invalid counters: got %d/%d want %d/%d", restored.IdExpr, restored.IdTask, o.IdExpr, o.IdTask)
+	}
+	if expr := restored.Exprs[2]; expr.Status != "resolved" || expr.Result != 7 || expr.Owner != "alice" {
+		t.Errorf("invalid expression: %+v", expr)
+	}
+	if task := restored.Tasks[2]; task.Operation != "+" || task.Arg1 != 2 || task.Arg2 != 4 || task.Deps[0] != 1 {
+		t.Errorf("invalid task: %+v", task)
+	}
+	if resp := submit(restored, "alice", "1+1"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("invalid status code: got %v want %v", resp.StatusCode, http.StatusCreated)
+	}
+	if _, ok := restored.Exprs[3]; !ok {
+		t.Error("a new expression reused an id from before the restart")
+	}
+
+	if err := restored.LoadState(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
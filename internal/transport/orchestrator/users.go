@@ -0,0 +1,93 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/auth"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/logging"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+)
+
+type User struct {
+	Login        string
+	PasswordHash []byte
+	CreatedAt    time.Time
+}
+
+func (o *Orchestrator) Register(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	var req models.ReqAuth
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Login == "" || req.Password == "" {
+		logger.Warn("an incorrect registration structure was sent")
+		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(req.Password) > auth.MaxPasswordBytes {
+		logger.Warn("a password too long to be hashed was sent", "user", req.Login)
+		http.Error(w, errors.ErrPasswordTooLong.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		logger.Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	o.Mu.Lock()
+	defer o.Mu.Unlock()
+
+	if _, ok := o.Users[req.Login]; ok {
+		logger.Warn("the user is already registered", "user", req.Login)
+		http.Error(w, errors.ErrUserExists.Error(), http.StatusConflict)
+		return
+	}
+	o.Users[req.Login] = &User{
+		Login:        req.Login,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}
+	logger.Info("the user was successfully registered", "user", req.Login)
+}
+
+func (o *Orchestrator) Login(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+	var req models.ReqAuth
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Login == "" || req.Password == "" {
+		logger.Warn("an incorrect login structure was sent")
+		http.Error(w, errors.ErrInvalidData.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	o.Mu.Lock()
+	user, ok := o.Users[req.Login]
+	o.Mu.Unlock()
+
+	// An unknown login is checked against a fixed hash, so it takes as long
+	// as a wrong password.
+	var hash []byte
+	if ok {
+		hash = user.PasswordHash
+	}
+	if !auth.CheckPassword(hash, req.Password) || !ok {
+		logger.Warn("failed login attempt", "user", req.Login)
+		http.Error(w, errors.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+	token, err := o.Auth.Issue(user.Login)
+	if err != nil {
+		logger.Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(models.RespLogin{Token: token}); err != nil {
+		logger.Error("server returned an error", "error", err)
+		http.Error(w, errors.ErrServerSide.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("the user successfully logged in", "user", user.Login)
+}
@@ -0,0 +1,119 @@
+package orchestrator_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/models"
+)
+
+func TestRegisterAndLogin(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(newOrchestrator(t).Router())
+	defer srv.Close()
+
+	post := func(path string, body interface{}) *http.Response {
+		jsonBytes, _ := json.Marshal(body)
+		res, err := http.Post(srv.URL+path, "application/json", bytes.NewBuffer(jsonBytes))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return res
+	}
+
+	testCases := []struct {
+		name               string
+		path               string
+		body               models.ReqAuth
+		expectedStatusCode int
+	}{
+		{"register", "/api/v1/register", models.ReqAuth{Login: "alice", Password: "qwerty"}, http.StatusOK},
+		{"register twice", "/api/v1/register", models.ReqAuth{Login: "alice", Password: "123456"}, http.StatusConflict},
+		{"register without password", "/api/v1/register", models.ReqAuth{Login: "bob"}, http.StatusUnprocessableEntity},
+		{"register with too long password", "/api/v1/register", models.ReqAuth{Login: "bob", Password: strings.Repeat("a", 73)}, http.StatusUnprocessableEntity},
+		{"login", "/api/v1/login", models.ReqAuth{Login: "alice", Password: "qwerty"}, http.StatusOK},
+		{"login with wrong password", "/api/v1/login", models.ReqAuth{Login: "alice", Password: "123456"}, http.StatusUnauthorized},
+		{"login of unknown user", "/api/v1/login", models.ReqAuth{Login: "bob", Password: "qwerty"}, http.StatusUnauthorized},
+	}
+	for _, ts := range testCases {
+		res := post(ts.path, ts.body)
+		res.Body.Close()
+		if res.StatusCode != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.name, res.StatusCode, ts.expectedStatusCode)
+		}
+	}
+}
+
+func TestExpressionsAreScopedToOwner(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(newOrchestrator(t).Router())
+	defer srv.Close()
+
+	do := func(method, path, token string, body interface{}) *http.Response {
+		var reader io.Reader
+		if body != nil {
+			jsonBytes, _ := json.Marshal(body)
+			reader = bytes.NewBuffer(jsonBytes)
+		}
+		r, _ := http.NewRequest(method, srv.URL+path, reader)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return res
+	}
+	login := func(name string) string {
+		do(http.MethodPost, "/api/v1/register", "", models.ReqAuth{Login: name, Password: "qwerty"}).Body.Close()
+		res := do(http.MethodPost, "/api/v1/login", "", models.ReqAuth{Login: name, Password: "qwerty"})
+		defer res.Body.Close()
+		var resp models.RespLogin
+		json.NewDecoder(res.Body).Decode(&resp)
+		return resp.Token
+	}
+	alice, bob := login("alice"), login("bob")
+
+	res := do(http.MethodPost, "/api/v1/calculate", "", models.ReqAddExpr{Expression: "2+2"})
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("anonymous submission: invalid status code: got %v want %v", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	res = do(http.MethodPost, "/api/v1/calculate", alice, models.ReqAddExpr{Expression: "2+2"})
+	var added models.RespAddExpr
+	json.NewDecoder(res.Body).Decode(&added)
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("invalid status code: got %v want %v", res.StatusCode, http.StatusCreated)
+	}
+
+	path := "/api/v1/expressions/" + strconv.Itoa(added.ID)
+	if res := do(http.MethodGet, path, alice, nil); res.StatusCode != http.StatusOK {
+		t.Errorf("owner: invalid status code: got %v want %v", res.StatusCode, http.StatusOK)
+	}
+	if res := do(http.MethodGet, path, bob, nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf("other user: invalid status code: got %v want %v", res.StatusCode, http.StatusNotFound)
+	}
+
+	for token, expected := range map[string]int{alice: 1, bob: 0} {
+		res := do(http.MethodGet, "/api/v1/expressions", token, nil)
+		var list struct {
+			Expressions []models.RespExpr `json:"expressions"`
+		}
+		json.NewDecoder(res.Body).Decode(&list)
+		res.Body.Close()
+		if len(list.Expressions) != expected {
+			t.Errorf("invalid number of listed expressions: got %v want %v", len(list.Expressions), expected)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package orchestrator
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// WebUI serves the dashboard. The pages are static and talk to the public
+// API from the browser, so they need no external resources and no handlers
+// of their own.
+func WebUI() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
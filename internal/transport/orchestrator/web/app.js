@@ -0,0 +1,259 @@
+"use strict";
+
+// The dashboard polls the public API, so it shows the same data as the
+// command-line client and needs nothing but the orchestrator itself.
+
+const refreshInterval = 1000;
+
+// Tasks handed out to an agent have the status "solved" in the API.
+const taskStatuses = {
+  untouched: "queued",
+  solved: "leased",
+  resolved: "resolved",
+  cancelled: "cancelled",
+};
+
+let token = localStorage.getItem("calc_token");
+let userName = localStorage.getItem("calc_user");
+let selected = null;
+let timer = null;
+
+const $ = (id) => document.getElementById(id);
+
+function element(tag, props, ...children) {
+  const el = document.createElement(tag);
+  Object.assign(el, props);
+  el.append(...children);
+  return el;
+}
+
+async function api(method, path, body) {
+  const headers = {};
+  if (token) {
+    headers["Authorization"] = "Bearer " + token;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const resp = await fetch("/api/v1" + path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (resp.status === 401 && token) {
+    logout();
+  }
+  if (!resp.ok) {
+    throw new Error((await resp.text()).trim() || resp.statusText);
+  }
+  const text = await resp.text();
+  return text ? JSON.parse(text) : null;
+}
+
+function formatNumber(n) {
+  return Number.isInteger(n) ? String(n) : String(Number(n.toPrecision(12)));
+}
+
+function formatTime(value) {
+  return new Date(value).toLocaleTimeString();
+}
+
+function show() {
+  const signedIn = Boolean(token);
+  $("login").hidden = signedIn;
+  $("dashboard").hidden = !signedIn;
+  $("user").hidden = !signedIn;
+  $("user-name").textContent = userName || "";
+  clearInterval(timer);
+  if (signedIn) {
+    refresh();
+    timer = setInterval(refresh, refreshInterval);
+  }
+}
+
+async function login(register) {
+  const form = $("login-form");
+  const credentials = { login: form.login.value, password: form.password.value };
+  $("login-error").textContent = "";
+  try {
+    if (register) {
+      await api("POST", "/register", credentials);
+    }
+    const resp = await api("POST", "/login", credentials);
+    token = resp.token;
+    userName = credentials.login;
+    localStorage.setItem("calc_token", token);
+    localStorage.setItem("calc_user", userName);
+    form.password.value = "";
+    show();
+  } catch (err) {
+    $("login-error").textContent = err.message;
+  }
+}
+
+function logout() {
+  token = null;
+  userName = null;
+  selected = null;
+  localStorage.removeItem("calc_token");
+  localStorage.removeItem("calc_user");
+  show();
+}
+
+async function submitExpression(event) {
+  event.preventDefault();
+  const form = $("submit-form");
+  $("submit-error").textContent = "";
+  try {
+    const resp = await api("POST", "/calculate", {
+      expression: form.expression.value,
+      disable_optimization: form.disable_optimization.checked,
+    });
+    form.expression.value = "";
+    selected = resp.id;
+    refresh();
+  } catch (err) {
+    $("submit-error").textContent = err.message;
+  }
+}
+
+async function cancelExpression(id) {
+  try {
+    await api("POST", "/expressions/" + id + "/cancel");
+    refresh();
+  } catch (err) {
+    alert(err.message);
+  }
+}
+
+function renderExpressions(expressions) {
+  const rows = expressions
+    .slice()
+    .sort((a, b) => b.id - a.id)
+    .map((expr) => {
+      const total = expr.tasks.total || 0;
+      const done = expr.tasks.resolved || 0;
+      let result = "";
+      if (expr.status === "resolved") {
+        result = formatNumber(expr.result);
+      } else if (expr.error) {
+        result = expr.error;
+      }
+      const actions = element("td");
+      if (expr.status === "not resolved") {
+        const cancel = element("button", { type: "button", textContent: "Cancel" });
+        cancel.addEventListener("click", (event) => {
+          event.stopPropagation();
+          cancelExpression(expr.id);
+        });
+        actions.append(cancel);
+      }
+      const row = element(
+        "tr",
+        { className: "selectable" + (expr.id === selected ? " selected" : "") },
+        element("td", { textContent: expr.id }),
+        element("td", { className: "body", textContent: expr.body }),
+        element("td", { className: "status-" + expr.status.replace(" ", "-"), textContent: expr.status }),
+        element("td", { textContent: done + "/" + total }),
+        element("td", { textContent: result }),
+        actions,
+      );
+      row.addEventListener("click", () => {
+        selected = expr.id;
+        refresh();
+      });
+      return row;
+    });
+  $("expressions").replaceChildren(...rows);
+}
+
+function renderStatus(status) {
+  $("queue-depth").textContent = status.queue_depth;
+  $("in-flight").textContent = status.in_flight;
+  const rows = status.agents.map((agent) =>
+    element(
+      "tr",
+      { className: agent.connected ? "" : "disconnected" },
+      element("td", { textContent: agent.id || "(unnamed)" }),
+      element("td", { textContent: agent.tasks_in_flight }),
+      element("td", { textContent: agent.tasks_completed }),
+      element("td", { textContent: formatTime(agent.last_seen) }),
+    ),
+  );
+  if (rows.length === 0) {
+    rows.push(element("tr", {}, element("td", { colSpan: 4, textContent: "no agents have connected yet" })));
+  }
+  $("agents").replaceChildren(...rows);
+}
+
+// renderTree draws the tasks as a tree: the task producing the value of the
+// expression is the root and the tasks it waits for are its children.
+function renderTree(tasks) {
+  const byID = new Map(tasks.map((task) => [task.id, task]));
+  const isDep = new Set(tasks.flatMap((task) => task.deps || []));
+  const node = (task) => {
+    let text = "#" + task.id + "  " + formatNumber(task.arg1) + " " + task.operation + " " + formatNumber(task.arg2);
+    if (task.status === "resolved") {
+      text += " = " + formatNumber(task.result);
+    }
+    text += "  [" + (taskStatuses[task.status] || task.status);
+    if (task.agent) {
+      text += ", " + task.agent;
+    }
+    text += "]";
+    const li = element("li", { className: "status-" + task.status, textContent: text });
+    const children = (task.deps || []).filter((id) => byID.has(id)).map((id) => node(byID.get(id)));
+    if (children.length > 0) {
+      li.append(element("ul", {}, ...children));
+    }
+    return li;
+  };
+  const roots = tasks.filter((task) => !isDep.has(task.id)).map(node);
+  if (roots.length === 0) {
+    $("tree").replaceChildren(element("p", { textContent: "the expression needs no tasks" }));
+    return;
+  }
+  $("tree").replaceChildren(element("ul", {}, ...roots));
+}
+
+async function renderDetails() {
+  if (selected === null) {
+    $("details").hidden = true;
+    return;
+  }
+  const [expr, tasks] = await Promise.all([
+    api("GET", "/expressions/" + selected),
+    api("GET", "/expressions/" + selected + "/tasks"),
+  ]);
+  $("details").hidden = false;
+  $("details-id").textContent = expr.expression.id;
+  $("details-body").textContent = expr.expression.body;
+  renderTree(tasks.tasks || []);
+}
+
+async function refresh() {
+  if (!token) {
+    return;
+  }
+  try {
+    const [expressions, status] = await Promise.all([api("GET", "/expressions"), api("GET", "/status")]);
+    renderExpressions(expressions.expressions || []);
+    renderStatus(status);
+    await renderDetails();
+  } catch (err) {
+    console.error(err);
+  }
+}
+
+$("login-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  login(false);
+});
+$("register").addEventListener("click", () => {
+  if ($("login-form").reportValidity()) {
+    login(true);
+  }
+});
+$("logout").addEventListener("click", logout);
+$("submit-form").addEventListener("submit", submitExpression);
+show();
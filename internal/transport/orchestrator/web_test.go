@@ -0,0 +1,40 @@
+package orchestrator_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebUI(t *testing.T) {
+	t.Parallel()
+
+	router := newOrchestrator(t).Router()
+	testCases := []struct {
+		path               string
+		expectedStatusCode int
+		expectedType       string
+	}{
+		{"/", http.StatusFound, ""},
+		{"/ui/", http.StatusOK, "text/html"},
+		{"/ui/app.js", http.StatusOK, "javascript"},
+		{"/ui/style.css", http.StatusOK, "text/css"},
+		{"/ui/missing.js", http.StatusNotFound, ""},
+	}
+	for _, ts := range testCases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ts.path, nil))
+		if w.Code != ts.expectedStatusCode {
+			t.Errorf("%s: invalid status code: got %v want %v", ts.path, w.Code, ts.expectedStatusCode)
+			continue
+		}
+		if !strings.Contains(w.Header().Get("Content-Type"), ts.expectedType) {
+			t.Errorf("%s: invalid content type: got %q want %q", ts.path, w.Header().Get("Content-Type"), ts.expectedType)
+		}
+		// The dashboard has to work without access to the internet.
+		if body := w.Body.String(); strings.Contains(body, "http://") || strings.Contains(body, "https://") {
+			t.Errorf("%s: refers to an external resource", ts.path)
+		}
+	}
+}
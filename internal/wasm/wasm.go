@@ -0,0 +1,210 @@
+// Package wasm runs user-defined functions compiled to WebAssembly. A
+// function takes one f64 argument and returns one f64. Modules run without
+// any host functions, so they can only compute: they cannot reach files, the
+// network or the clock of the agent.
+package wasm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Limits bounds what one call of a function may use. Zero values disable the
+// corresponding limit.
+type Limits struct {
+	// MemoryPages is the largest memory a module may have, in pages of
+	// 64 KiB.
+	MemoryPages uint32
+	// Timeout is how long one call may run.
+	Timeout time.Duration
+	// Modules is how many compiled modules are kept. The least recently
+	// used one is dropped when another is compiled.
+	Modules int
+}
+
+// Runtime compiles modules once and calls their functions in a fresh
+// instance every time, so calls do not share any state.
+type Runtime struct {
+	runtime    wazero.Runtime
+	timeout    time.Duration
+	maxModules int
+	mu         sync.Mutex
+	modules    map[string]*compiledModule
+	clock      uint64
+}
+
+// compiledModule is a module in the cache. A dropped module is closed when
+// its last call returns.
+type compiledModule struct {
+	compiled wazero.CompiledModule
+	used     uint64
+	calls    int
+	dropped  bool
+}
+
+func NewRuntime(ctx context.Context, limits Limits) *Runtime {
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if limits.MemoryPages > 0 {
+		config = config.WithMemoryLimitPages(limits.MemoryPages)
+	}
+	return &Runtime{
+		runtime:    wazero.NewRuntimeWithConfig(ctx, config),
+		timeout:    limits.Timeout,
+		maxModules: limits.Modules,
+		modules:    make(map[string]*compiledModule),
+	}
+}
+
+// Close releases the compiled modules.
+func (r *Runtime) Close(ctx context.Context) error {
+	return r.runtime.Close(ctx)
+}
+
+// Digest identifies the module by its content.
+func Digest(module []byte) string {
+	sum := sha256.Sum256(module)
+	return hex.EncodeToString(sum[:])
+}
+
+// Validate checks that the module can be run as the function name: it must
+// compile, import nothing and export the function taking one f64 and
+// returning one f64.
+func Validate(ctx context.Context, module []byte, name string) error {
+	r := wazero.NewRuntime(ctx)
+	defer r.Close(ctx)
+	compiled, err := r.CompileModule(ctx, module)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrInvalidModule, err)
+	}
+	return check(compiled, name)
+}
+
+func check(compiled wazero.CompiledModule, name string) error {
+	if len(compiled.ImportedFunctions()) > 0 || len(compiled.ImportedMemories()) > 0 {
+		return fmt.Errorf("%w: the module must not import anything", errors.ErrInvalidModule)
+	}
+	def, ok := compiled.ExportedFunctions()[name]
+	if !ok {
+		return fmt.Errorf("%w: the module does not export %q", errors.ErrInvalidModule, name)
+	}
+	params, results := def.ParamTypes(), def.ResultTypes()
+	if len(params) != 1 || params[0] != api.ValueTypeF64 || len(results) != 1 || results[0] != api.ValueTypeF64 {
+		return fmt.Errorf("%w: %q must take one f64 and return one f64", errors.ErrInvalidModule, name)
+	}
+	return nil
+}
+
+// Compiled reports whether the module with the digest is already compiled,
+// so the caller does not need to fetch it.
+func (r *Runtime) Compiled(digest string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.modules[digest]
+	return ok
+}
+
+// Compile compiles the module and keeps it under the digest. The module is
+// rejected when its content does not match the digest.
+func (r *Runtime) Compile(ctx context.Context, digest string, module []byte) error {
+	if Digest(module) != digest {
+		return fmt.Errorf("%w: the module does not match its digest", errors.ErrInvalidModule)
+	}
+	compiled, err := r.runtime.CompileModule(ctx, module)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errors.ErrInvalidModule, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.modules[digest]; ok {
+		compiled.Close(ctx)
+		return nil
+	}
+	if r.maxModules > 0 && len(r.modules) >= r.maxModules {
+		r.dropLeastUsed(ctx)
+	}
+	r.clock++
+	r.modules[digest] = &compiledModule{compiled: compiled, used: r.clock}
+	return nil
+}
+
+// dropLeastUsed removes the least recently used module from the cache. The
+// caller must hold r.mu.
+func (r *Runtime) dropLeastUsed(ctx context.Context) {
+	var digest string
+	var oldest *compiledModule
+	for d, m := range r.modules {
+		if oldest == nil || m.used < oldest.used {
+			digest, oldest = d, m
+		}
+	}
+	delete(r.modules, digest)
+	oldest.dropped = true
+	if oldest.calls == 0 {
+		oldest.compiled.Close(ctx)
+	}
+}
+
+// Call calls the function name of the compiled module with the digest. A
+// call running longer than the timeout is stopped with
+// errors.ErrFunctionTimeout, a trap of the module is returned as
+// errors.ErrFunctionFailed. A module that is not compiled, or was dropped
+// from the cache, fails with errors.ErrNotCompiled.
+func (r *Runtime) Call(ctx context.Context, digest, name string, arg float64) (float64, error) {
+	r.mu.Lock()
+	m, ok := r.modules[digest]
+	if ok {
+		r.clock++
+		m.used = r.clock
+		m.calls++
+	}
+	r.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", errors.ErrNotCompiled, digest)
+	}
+	defer func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if m.calls--; m.calls == 0 && m.dropped {
+			m.compiled.Close(context.Background())
+		}
+	}()
+	compiled := m.compiled
+	if err := check(compiled, name); err != nil {
+		return 0, err
+	}
+
+	callCtx := ctx
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	// Every call gets an anonymous instance of its own, which is closed
+	// when the call returns.
+	mod, err := r.runtime.InstantiateModule(callCtx, compiled, wazero.NewModuleConfig().WithName(""))
+	if err == nil {
+		defer mod.Close(context.Background())
+		var results []uint64
+		results, err = mod.ExportedFunction(name).Call(callCtx, api.EncodeF64(arg))
+		if err == nil {
+			return api.DecodeF64(results[0]), nil
+		}
+	}
+	switch {
+	case ctx.Err() != nil:
+		return 0, ctx.Err()
+	case stderrors.Is(callCtx.Err(), context.DeadlineExceeded):
+		return 0, fmt.Errorf("%w: %s", errors.ErrFunctionTimeout, r.timeout)
+	default:
+		return 0, fmt.Errorf("%w: %v", errors.ErrFunctionFailed, err)
+	}
+}
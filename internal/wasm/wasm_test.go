@@ -0,0 +1,148 @@
+package wasm_test
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+	"time"
+
+	calcerrors "github.com/kingofhandsomes/distributed_calculator_go/internal/errors"
+	"github.com/kingofhandsomes/distributed_calculator_go/internal/wasm"
+)
+
+// The modules are written by hand in the binary format. Each has one
+// function of the type (f64) -> f64.
+var (
+	header   = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	funcType = []byte{0x01, 0x06, 0x01, 0x60, 0x01, 0x7c, 0x01, 0x7c}
+	// double returns x+x.
+	double = module(
+		[]byte{0x03, 0x02, 0x01, 0x00},
+		[]byte{0x07, 0x0a, 0x01, 0x06, 'd', 'o', 'u', 'b', 'l', 'e', 0x00, 0x00},
+		[]byte{0x0a, 0x09, 0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x00, 0xa0, 0x0b},
+	)
+	// spin never returns.
+	spin = module(
+		[]byte{0x03, 0x02, 0x01, 0x00},
+		[]byte{0x07, 0x08, 0x01, 0x04, 's', 'p', 'i', 'n', 0x00, 0x00},
+		[]byte{0x0a, 0x12, 0x01, 0x10, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x44, 0, 0, 0, 0, 0, 0, 0, 0, 0x0b},
+	)
+	// fail traps.
+	fail = module(
+		[]byte{0x03, 0x02, 0x01, 0x00},
+		[]byte{0x07, 0x08, 0x01, 0x04, 'f', 'a', 'i', 'l', 0x00, 0x00},
+		[]byte{0x0a, 0x05, 0x01, 0x03, 0x00, 0x00, 0x0b},
+	)
+	// greedy is double with a memory of 100 pages.
+	greedy = module(
+		[]byte{0x03, 0x02, 0x01, 0x00},
+		[]byte{0x05, 0x03, 0x01, 0x00, 0x64},
+		[]byte{0x07, 0x0a, 0x01, 0x06, 'd', 'o', 'u', 'b', 'l', 'e', 0x00, 0x00},
+		[]byte{0x0a, 0x09, 0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x00, 0xa0, 0x0b},
+	)
+	// host imports env.f and exports it as double.
+	host = module(
+		[]byte{0x02, 0x09, 0x01, 0x03, 'e', 'n', 'v', 0x01, 'f', 0x00, 0x00},
+		[]byte{0x07, 0x0a, 0x01, 0x06, 'd', 'o', 'u', 'b', 'l', 'e', 0x00, 0x00},
+	)
+)
+
+func module(sections ...[]byte) []byte {
+	m := append(append([]byte{}, header...), funcType...)
+	for _, section := range sections {
+		m = append(m, section...)
+	}
+	return m
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		module []byte
+		export string
+		valid  bool
+	}{
+		{"valid", double, "double", true},
+		{"missing export", double, "triple", false},
+		{"not a module", []byte("double"), "double", false},
+		{"imports", host, "double", false},
+	}
+	for _, ts := range testCases {
+		err := wasm.Validate(context.Background(), ts.module, ts.export)
+		if (err == nil) != ts.valid || (err != nil && !stderrors.Is(err, calcerrors.ErrInvalidModule)) {
+			t.Errorf("%s: unexpected error: %v", ts.name, err)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	r := wasm.NewRuntime(ctx, wasm.Limits{MemoryPages: 16, Timeout: 100 * time.Millisecond})
+	defer r.Close(ctx)
+
+	for _, m := range [][]byte{double, spin, fail} {
+		if err := r.Compile(ctx, wasm.Digest(m), m); err != nil {
+			t.Fatalf("failed to compile: %v", err)
+		}
+	}
+	if err := r.Compile(ctx, wasm.Digest(greedy), greedy); !stderrors.Is(err, calcerrors.ErrInvalidModule) {
+		t.Errorf("a module over the memory limit was compiled: %v", err)
+	}
+	if err := r.Compile(ctx, wasm.Digest(double), spin); !stderrors.Is(err, calcerrors.ErrInvalidModule) {
+		t.Errorf("a module not matching its digest was compiled: %v", err)
+	}
+	if !r.Compiled(wasm.Digest(double)) || r.Compiled(wasm.Digest(greedy)) {
+		t.Error("invalid compiled modules")
+	}
+
+	testCases := []struct {
+		name        string
+		module      []byte
+		export      string
+		expected    float64
+		expectedErr error
+	}{
+		{"result", double, "double", 5, nil},
+		{"timeout", spin, "spin", 0, calcerrors.ErrFunctionTimeout},
+		{"trap", fail, "fail", 0, calcerrors.ErrFunctionFailed},
+		{"not compiled", greedy, "double", 0, calcerrors.ErrNotCompiled},
+		{"missing export", double, "spin", 0, calcerrors.ErrInvalidModule},
+	}
+	for _, ts := range testCases {
+		result, err := r.Call(ctx, wasm.Digest(ts.module), ts.export, 2.5)
+		if !stderrors.Is(err, ts.expectedErr) || result != ts.expected {
+			t.Errorf("%s: got %v %v want %v %v", ts.name, result, err, ts.expected, ts.expectedErr)
+		}
+	}
+}
+
+func TestModuleCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	r := wasm.NewRuntime(ctx, wasm.Limits{Timeout: 100 * time.Millisecond, Modules: 2})
+	defer r.Close(ctx)
+
+	for _, m := range [][]byte{double, fail} {
+		if err := r.Compile(ctx, wasm.Digest(m), m); err != nil {
+			t.Fatalf("failed to compile: %v", err)
+		}
+	}
+	// double is used after fail, so fail is dropped for spin.
+	if _, err := r.Call(ctx, wasm.Digest(double), "double", 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Compile(ctx, wasm.Digest(spin), spin); err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if !r.Compiled(wasm.Digest(double)) || !r.Compiled(wasm.Digest(spin)) || r.Compiled(wasm.Digest(fail)) {
+		t.Error("invalid compiled modules")
+	}
+	if _, err := r.Call(ctx, wasm.Digest(fail), "fail", 1); !stderrors.Is(err, calcerrors.ErrNotCompiled) {
+		t.Errorf("a dropped module was called: %v", err)
+	}
+}